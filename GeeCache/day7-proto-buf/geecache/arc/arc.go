@@ -0,0 +1,266 @@
+package arc
+
+import (
+	"container/list"
+	"geecache/lru"
+	"time"
+)
+
+// Value 与 lru.Value 相同，使用 Len 来计算它需要多少字节
+type Value = lru.Value
+
+// EvictReason 与 lru.EvictReason 相同，表示记录被移除的原因
+type EvictReason = lru.EvictReason
+
+// Cache is an ARC (Adaptive Replacement Cache). It is not safe for concurrent access.
+//
+// ARC 同时维护两个 LRU 队列：t1 存放只访问过一次的记录（体现"最近"），
+// t2 存放访问过至少两次的记录（体现"频率"）。b1、b2 是对应的幽灵队列，
+// 只记录最近从 t1、t2 淘汰的键。命中幽灵队列说明对应的队列太小了，
+// 于是自适应地调整 t1 的目标大小 p。这里的容量和 p 都以字节为单位。
+type Cache struct {
+	maxBytes int64 // 允许使用的最大内存，0 表示不限制
+	p        int64 // t1 的目标大小
+
+	t1, t2 *list.List // 真实记录，front 为最近访问
+	b1, b2 *list.List // 幽灵记录，只保存 key 和大小
+
+	t1Bytes, t2Bytes int64
+	b1Bytes, b2Bytes int64
+
+	cache map[string]*list.Element // t1、t2 中的记录
+	ghost map[string]*list.Element // b1、b2 中的记录
+	// 可选并在清除entry时执行。
+	OnEvicted func(key string, value Value)
+	// 可选，与 OnEvicted 相同，但额外带上移除原因
+	OnEvictedWithReason func(key string, value Value, reason EvictReason)
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time  // 过期时间，零值表示永不过期
+	ll     *list.List // 所在的队列
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+type ghostEntry struct {
+	key  string
+	size int64
+	ll   *list.List
+}
+
+// New 是 Cache 的构造函数
+func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		t1:        list.New(),
+		t2:        list.New(),
+		b1:        list.New(),
+		b2:        list.New(),
+		cache:     make(map[string]*list.Element),
+		ghost:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Get 查找键的值，命中后记录被移到 t2 的队尾
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(ele, lru.EvictExpired)
+			return nil, false
+		}
+		c.moveTo(ele, c.t2)
+		return kv.value, true
+	}
+	return
+}
+
+// moveTo 把记录移到队列 ll 的 front，并更新两个队列的大小
+func (c *Cache) moveTo(ele *list.Element, ll *list.List) {
+	kv := ele.Value.(*entry)
+	if kv.ll == ll {
+		ll.MoveToFront(ele)
+		return
+	}
+	c.detach(ele)
+	kv.ll = ll
+	c.cache[kv.key] = ll.PushFront(kv)
+	c.addBytes(ll, kv.size())
+}
+
+// detach 从所在队列中删除记录，但不触发回调
+func (c *Cache) detach(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	kv.ll.Remove(ele)
+	delete(c.cache, kv.key)
+	c.addBytes(kv.ll, -kv.size())
+}
+
+func (c *Cache) addBytes(ll *list.List, n int64) {
+	switch ll {
+	case c.t1:
+		c.t1Bytes += n
+	case c.t2:
+		c.t2Bytes += n
+	case c.b1:
+		c.b1Bytes += n
+	case c.b2:
+		c.b2Bytes += n
+	}
+}
+
+// Remove 从缓存中删除 key 对应的记录
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, lru.EvictRemoved)
+	}
+	if ele, ok := c.ghost[key]; ok {
+		c.removeGhost(ele)
+	}
+}
+
+// RemoveExpired 移除所有已过期的记录，返回移除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, ll := range []*list.List{c.t1, c.t2} {
+		for ele := ll.Back(); ele != nil; {
+			prev := ele.Prev()
+			if ele.Value.(*entry).expired(now) {
+				c.removeElement(ele, lru.EvictExpired)
+				n++
+			}
+			ele = prev
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason EvictReason) {
+	kv := ele.Value.(*entry)
+	c.detach(ele)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+	if c.OnEvictedWithReason != nil {
+		c.OnEvictedWithReason(kv.key, kv.value, reason)
+	}
+}
+
+func (c *Cache) removeGhost(ele *list.Element) {
+	g := ele.Value.(*ghostEntry)
+	g.ll.Remove(ele)
+	delete(c.ghost, g.key)
+	c.addBytes(g.ll, -g.size)
+}
+
+// evict 淘汰 t1 或 t2 最久没有访问的记录，并把它的 key 放入对应的幽灵队列。
+// t1 超出目标大小 p 时淘汰 t1，否则淘汰 t2。
+func (c *Cache) evict(hitB2 bool) {
+	from, to := c.t2, c.b2
+	if c.t1.Len() > 0 && (c.t1Bytes > c.p || (hitB2 && c.t1Bytes == c.p) || c.t2.Len() == 0) {
+		from, to = c.t1, c.b1
+	}
+	ele := from.Back()
+	kv := ele.Value.(*entry)
+	c.removeElement(ele, lru.EvictCapacity)
+	c.ghost[kv.key] = to.PushFront(&ghostEntry{key: kv.key, size: kv.size(), ll: to})
+	c.addBytes(to, kv.size())
+}
+
+// trimGhosts 限制幽灵队列的大小：t1+b1 与 t2+b2 都不超过 maxBytes
+func (c *Cache) trimGhosts() {
+	for c.b1.Len() > 0 && c.t1Bytes+c.b1Bytes > c.maxBytes {
+		c.removeGhost(c.b1.Back())
+	}
+	for c.b2.Len() > 0 && c.t2Bytes+c.b2Bytes > c.maxBytes {
+		c.removeGhost(c.b2.Back())
+	}
+}
+
+// Add 向缓存中添加一个值，该值永不过期
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL 向缓存中添加一个值，ttl 之后该值过期；ttl <= 0 表示永不过期。
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	kv := &entry{key: key, value: value, expire: expire}
+	hitB2 := false
+
+	if ele, ok := c.cache[key]; ok { // 已在缓存中：更新值，视为一次访问
+		old := ele.Value.(*entry)
+		c.addBytes(old.ll, kv.size()-old.size())
+		old.value, old.expire = value, expire
+		c.moveTo(ele, c.t2)
+	} else if ele, ok := c.ghost[key]; ok { // 命中幽灵队列：调整 p，放入 t2
+		g := ele.Value.(*ghostEntry)
+		if g.ll == c.b1 { // t1 淘汰得太早，增大 t1 的目标大小
+			c.p = min64(c.maxBytes, c.p+max64(ratio(c.b2Bytes, c.b1Bytes), 1)*kv.size())
+		} else { // t2 淘汰得太早，减小 t1 的目标大小
+			c.p = max64(0, c.p-max64(ratio(c.b1Bytes, c.b2Bytes), 1)*kv.size())
+			hitB2 = true
+		}
+		c.removeGhost(ele)
+		kv.ll = c.t2
+		c.cache[key] = c.t2.PushFront(kv)
+		c.addBytes(c.t2, kv.size())
+	} else { // 全新的记录放入 t1
+		kv.ll = c.t1
+		c.cache[key] = c.t1.PushFront(kv)
+		c.addBytes(c.t1, kv.size())
+	}
+
+	if c.maxBytes == 0 {
+		return
+	}
+	for c.t1Bytes+c.t2Bytes > c.maxBytes {
+		c.evict(hitB2)
+	}
+	c.trimGhosts()
+}
+
+func ratio(a, b int64) int64 {
+	if b == 0 {
+		return 1
+	}
+	return a / b
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Len 用来获取添加了多少条数据（不包括幽灵记录）
+func (c *Cache) Len() int {
+	return c.t1.Len() + c.t2.Len()
+}
+
+// Bytes 返回当前已使用的内存（不包括幽灵记录）
+func (c *Cache) Bytes() int64 {
+	return c.t1Bytes + c.t2Bytes
+}
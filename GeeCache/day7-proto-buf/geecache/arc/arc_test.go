@@ -0,0 +1,72 @@
+package arc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// 测试 ARC 对扫描的抵抗：访问过两次的记录不会被一次性的扫描冲掉
+func TestScanResistance(t *testing.T) {
+	arc := New(int64(10*len("k0v0")), nil)
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("k%d", i)
+		arc.Add(key, String(fmt.Sprintf("v%d", i)))
+		arc.Get(key)
+	}
+	for i := 0; i < 100; i++ { // 一次性扫描
+		arc.Add(fmt.Sprintf("s%02d", i), String("v"))
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := arc.Get(fmt.Sprintf("k%d", i)); !ok {
+			t.Fatalf("frequently used k%d should survive the scan", i)
+		}
+	}
+	if arc.Bytes() > arc.maxBytes {
+		t.Fatalf("cache uses %d bytes, exceeds %d", arc.Bytes(), arc.maxBytes)
+	}
+}
+
+// 测试命中幽灵队列后自适应地调整 t1 的目标大小
+func TestAdapt(t *testing.T) {
+	evicted := 0
+	arc := New(int64(4*len("k0v0")), func(key string, value Value) {
+		evicted++
+	})
+	for i := 0; i < 2; i++ { // k0、k1 访问过两次，进入 t2
+		arc.Add(fmt.Sprintf("k%d", i), String("v0"))
+		arc.Get(fmt.Sprintf("k%d", i))
+	}
+	for i := 2; i < 5; i++ {
+		arc.Add(fmt.Sprintf("k%d", i), String("v0"))
+	}
+	if evicted != 1 || arc.b1.Len() != 1 {
+		t.Fatalf("expected k2 evicted into b1, got %d evictions", evicted)
+	}
+	arc.Add("k2", String("v0")) // k2 在 b1 中
+	if arc.p == 0 || arc.t2.Len() != 3 {
+		t.Fatalf("hit in b1 should grow p and insert into t2, p = %d", arc.p)
+	}
+}
+
+func TestAddWithTTL(t *testing.T) {
+	arc := New(int64(0), nil)
+	arc.AddWithTTL("k1", String("v1"), 10*time.Millisecond)
+	arc.AddWithTTL("k2", String("v2"), 10*time.Millisecond)
+	arc.Add("k3", String("v3"))
+	arc.Get("k2")
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := arc.Get("k1"); ok {
+		t.Fatalf("k1 should be expired")
+	}
+	if n := arc.RemoveExpired(); n != 1 || arc.Len() != 1 || arc.Bytes() != int64(len("k3v3")) {
+		t.Fatalf("expected only k3 left, removed %d", n)
+	}
+}
@@ -0,0 +1,338 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "geecache/geecachepb"
+	"geecache/singleflight"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxBatchLoads 是一次 GetMulti 中同时进行的单个加载数的上限
+const maxBatchLoads = 16
+
+// BatchGetter 一次从数据源加载多个 key，返回的 map 中没有的 key 视为不存在(ErrNotFound)。
+// Getter 如果同时实现了 BatchGetter，GetMulti 对本节点负责的未命中 key 只调用一次 GetMulti。
+// 批量加载的值没有有效期。
+type BatchGetter interface {
+	GetMulti(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// BatchGetterFunc 通过一个函数实现BatchGetter，同时也实现了Getter，可以直接传给NewGroup。
+type BatchGetterFunc func(ctx context.Context, keys []string) (map[string][]byte, error)
+
+// GetMulti实现了BatchGetter接口功能
+func (f BatchGetterFunc) GetMulti(ctx context.Context, keys []string) (map[string][]byte, error) {
+	return f(ctx, keys)
+}
+
+// Get实现了Getter接口功能，使用 context.Background() 加载一个 key
+func (f BatchGetterFunc) Get(key string) ([]byte, error) {
+	return getOne(context.Background(), f, key)
+}
+
+// getOne 通过 BatchGetter 加载单个 key
+func getOne(ctx context.Context, getter BatchGetter, key string) ([]byte, error) {
+	values, err := getter.GetMulti(ctx, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	value, ok := values[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}
+	return value, nil
+}
+
+// GetMulti 一次获取多个 key 的值。
+// 本地缓存命中的 key 直接返回，其余 key 按所属节点分组，每个远程节点只发一次批量请求，
+// 本节点负责的 key 一起加载（数据源实现了 BatchGetter 时只调用一次，否则最多 maxBatchLoads 个并发）。
+// 与 Get 相同，每个 key 都经过 singleflight，正在被其他调用者加载的 key 等待原来的结果。
+// 返回的 map 只包含找到的 key，不存在的 key 不在其中；
+// 其他原因加载失败的 key 通过 error 报告，此时 map 中仍然包含加载成功的 key。
+func (g *Group) GetMulti(keys []string) (map[string]ByteView, error) {
+	return g.GetMultiContext(context.Background(), keys)
+}
+
+// GetMultiContext 与 GetMulti 相同，ctx 会传给远程节点和数据源
+func (g *Group) GetMultiContext(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	values, errs := g.getMulti(ctx, keys, false)
+	var failed []string
+	for key, err := range errs {
+		if !errors.Is(err, ErrNotFound) {
+			failed = append(failed, key)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return values, fmt.Errorf("get %d of %d keys failed, first error: %s: %v", len(failed), len(keys), failed[0], errs[failed[0]])
+	}
+	return values, nil
+}
+
+// multiResult 收集 getMulti 中并发加载的结果
+type multiResult struct {
+	mu     sync.Mutex
+	values map[string]ByteView
+	errs   map[string]error // 每个失败的 key 的错误，包括 ErrNotFound
+}
+
+func (r *multiResult) set(key string, value ByteView, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.errs[key] = err
+		return
+	}
+	r.values[key] = value
+}
+
+// getMulti 返回找到的值和每个失败的 key 的错误，重复的 key 只处理一次。
+// fromPeer 为 true 时是其他节点转发来的请求，与 getForPeer 相同，未命中的 key 都从本地加载
+func (g *Group) getMulti(ctx context.Context, keys []string, fromPeer bool) (map[string]ByteView, map[string]error) {
+	res := &multiResult{
+		values: make(map[string]ByteView, len(keys)),
+		errs:   make(map[string]error),
+	}
+	var local []string
+	remote := make(map[PeerGetter][]string)
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		g.stats.gets.Add(1)
+		if key == "" {
+			res.set(key, ByteView{}, fmt.Errorf("key is required"))
+			continue
+		}
+		if v, ok, err := g.lookupCache(key); ok {
+			res.set(key, v, err)
+			continue
+		}
+		if g.peers != nil && !fromPeer {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				remote[peer] = append(remote[peer], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+
+	// 其他节点转发来的请求使用单独的 singleflight，原因见 loadForPeer
+	loader := g.loader
+	if fromPeer {
+		loader = g.peerLoader
+	}
+	var wg sync.WaitGroup
+	for peer, keys := range remote {
+		wg.Add(1)
+		go func(peer PeerGetter, keys []string) {
+			defer wg.Done()
+			g.getMultiFromPeer(ctx, peer, keys, res)
+		}(peer, keys)
+	}
+	if len(local) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.loadMulti(ctx, loader, local, res, g.getMultiLocally)
+		}()
+	}
+	wg.Wait()
+	return res.values, res.errs
+}
+
+// loadMulti 通过 loader 加载 keys：正在被其他调用者加载的 key 等待原来的结果，
+// 其余 key 只调用一次 fetch 批量加载，与 load 相同地计入统计
+func (g *Group) loadMulti(ctx context.Context, loader *singleflight.Group, keys []string, res *multiResult,
+	fetch func(ctx context.Context, keys []string) (map[string]ByteView, map[string]error)) {
+	g.stats.loads.Add(int64(len(keys)))
+	results := loader.DoMulti(ctx, keys, func(ctx context.Context, keys []string) (map[string]interface{}, map[string]error) {
+		g.stats.loadsDeduped.Add(int64(len(keys)))
+		defer func(start time.Time) { g.loadLatency.observe(time.Since(start)) }(time.Now())
+		values, errs := fetch(ctx, keys)
+		vals := make(map[string]interface{}, len(values))
+		for key, value := range values {
+			vals[key] = value
+		}
+		return vals, errs
+	})
+	for key, r := range results {
+		if r.Err != nil {
+			res.set(key, ByteView{}, r.Err)
+			continue
+		}
+		value, ok := r.Val.(ByteView)
+		if !ok {
+			res.set(key, ByteView{}, fmt.Errorf("%s: %w", key, ErrNotFound))
+			continue
+		}
+		res.set(key, value, nil)
+	}
+}
+
+// forEachLimited 并发地对每个 key 调用 fn，同时最多 maxBatchLoads 个
+func forEachLimited(keys []string, fn func(key string)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxBatchLoads)
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fn(key)
+		}(key)
+	}
+	wg.Wait()
+}
+
+// loadEach 并发地调用 load 加载每个 key，同时最多 maxBatchLoads 个
+func (g *Group) loadEach(ctx context.Context, keys []string, res *multiResult, load func(ctx context.Context, key string) (ByteView, error)) {
+	forEachLimited(keys, func(key string) {
+		value, err := load(ctx, key)
+		res.set(key, value, err)
+	})
+}
+
+// getMultiFromPeer 用一次批量请求从远程节点获取 keys，远程节点不支持批量请求时逐个获取
+func (g *Group) getMultiFromPeer(ctx context.Context, peer PeerGetter, keys []string, res *multiResult) {
+	batcher, ok := peer.(BatchPeerGetter)
+	if !ok {
+		g.loadEach(ctx, keys, res, g.load)
+		return
+	}
+	g.loadMulti(ctx, g.loader, keys, res, func(ctx context.Context, keys []string) (map[string]ByteView, map[string]error) {
+		return g.fetchMultiFromPeer(ctx, batcher, keys)
+	})
+}
+
+// fetchMultiFromPeer 向远程节点发送批量请求。与 load 相同，远程节点确认不存在的 key 不再回退到本地加载，
+// 其余失败的 key 从本地数据源加载。调用方已经通过 singleflight 持有这些 key
+func (g *Group) fetchMultiFromPeer(ctx context.Context, batcher BatchPeerGetter, keys []string) (map[string]ByteView, map[string]error) {
+	out := &pb.BatchResponse{}
+	req := &pb.BatchRequest{Group: g.name, Keys: keys, Ring: g.ringFingerprint(), Hops: 1}
+	if err := batcher.GetMulti(ctx, req, out); err != nil {
+		g.stats.peerErrors.Add(1)
+		log.Println("[GeeCache] Failed to get batch from peer", err)
+		return g.getMultiLocally(ctx, keys)
+	}
+
+	notFound := make(map[string]bool, len(out.GetNotFound()))
+	for _, key := range out.GetNotFound() {
+		notFound[key] = true
+	}
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+	var failed []string
+	for _, key := range keys {
+		if r, ok := out.GetValues()[key]; ok {
+			value := viewFromResponse(r)
+			g.populatePeerValue(key, value)
+			values[key] = value
+		} else if notFound[key] {
+			g.populateNegative(key)
+			errs[key] = ErrNotFound
+		} else {
+			failed = append(failed, key)
+		}
+	}
+	if len(failed) > 0 {
+		g.stats.peerErrors.Add(1)
+		local, localErrs := g.getMultiLocally(ctx, failed)
+		for key, value := range local {
+			values[key] = value
+		}
+		for key, err := range localErrs {
+			errs[key] = err
+		}
+	}
+	return values, errs
+}
+
+// getMultiLocally 从本地数据源加载 keys：数据源实现了 BatchGetter 时只调用一次，
+// 否则并发地逐个加载，同时最多 maxBatchLoads 个。调用方已经通过 singleflight 持有这些 key
+func (g *Group) getMultiLocally(ctx context.Context, keys []string) (map[string]ByteView, map[string]error) {
+	values := make(map[string]ByteView, len(keys))
+	errs := make(map[string]error)
+	batch, ok := g.getter.(BatchGetter)
+	if !ok {
+		var mu sync.Mutex
+		forEachLimited(keys, func(key string) {
+			value, err := g.getLocally(ctx, key)
+			g.countLocal(key, err)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[key] = err
+				return
+			}
+			values[key] = value
+		})
+		return values, errs
+	}
+
+	done := g.trackLocalLoads(len(keys))
+	start := time.Now()
+	found, err := batch.GetMulti(ctx, keys)
+	cost := time.Since(start)
+	done()
+	for _, key := range keys {
+		if err != nil {
+			g.countLocal(key, err)
+			errs[key] = err
+			continue
+		}
+		b, ok := found[key]
+		if !ok {
+			g.countLocal(key, ErrNotFound)
+			errs[key] = ErrNotFound
+			continue
+		}
+		value := ByteView{b: cloneBytes(b)}
+		if g.refresh != nil {
+			g.refresh.setDeadlines(&value, 0, cost)
+		}
+		g.populateCache(key, value)
+		g.countLocal(key, nil)
+		values[key] = value
+	}
+	return values, errs
+}
+
+// countLocal 记录一次本地加载的结果，不存在的 key 放入负缓存
+func (g *Group) countLocal(key string, err error) {
+	if err == nil {
+		g.stats.localLoads.Add(1)
+		return
+	}
+	g.stats.localLoadErrs.Add(1)
+	if errors.Is(err, ErrNotFound) {
+		g.populateNegative(key)
+	}
+}
+
+// batchResponse 处理发来的批量请求，不存在的 key 放在 NotFound 中，其他失败的 key 不返回。
+// 来自其他节点的请求(hops > 0)只在本地处理
+func (g *Group) batchResponse(ctx context.Context, in *pb.BatchRequest) *pb.BatchResponse {
+	values, errs := g.getMulti(ctx, in.GetKeys(), in.GetHops() > 0)
+	res := &pb.BatchResponse{Values: make(map[string]*pb.Response, len(values))}
+	for key, value := range values {
+		res.Values[key] = newResponse(value)
+	}
+	for key, err := range errs {
+		if errors.Is(err, ErrNotFound) {
+			res.NotFound = append(res.NotFound, key)
+		}
+	}
+	sort.Strings(res.NotFound)
+	return res
+}
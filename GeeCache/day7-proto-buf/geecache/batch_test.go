@@ -0,0 +1,247 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	pb "geecache/geecachepb"
+)
+
+// batchGetter 记录每次批量加载的 key
+type batchGetter struct {
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (b *batchGetter) GetMulti(_ context.Context, keys []string) (map[string][]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	b.calls = append(b.calls, sorted)
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if v, ok := db[key]; ok {
+			values[key] = []byte(v)
+		}
+	}
+	return values, nil
+}
+
+func TestGetMulti(t *testing.T) {
+	getter := &batchGetter{}
+	gee := NewGroup("multi-scores", 2<<10, BatchGetterFunc(getter.GetMulti), WithNegativeCache(time.Minute, 1<<10))
+
+	values, err := gee.GetMulti([]string{"Tom", "Jack", "unknown", "Tom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["Tom"].String() != "630" || values["Jack"].String() != "589" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if len(getter.calls) != 1 || len(getter.calls[0]) != 3 {
+		t.Fatalf("expected one batch of 3 keys, got %v", getter.calls)
+	}
+
+	// 再次获取时全部命中缓存或负缓存
+	if values, err = gee.GetMulti([]string{"Tom", "Jack", "unknown"}); err != nil || len(values) != 2 || len(getter.calls) != 1 {
+		t.Fatalf("expected cached results, got %v (%v), calls %v", values, err, getter.calls)
+	}
+
+	// 单个 Get 也能使用 BatchGetter
+	if view, err := gee.Get("Sam"); err != nil || view.String() != "567" {
+		t.Fatalf("expected Sam=567, got %s (%v)", view, err)
+	}
+	if _, err := gee.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+// GetMulti 与同时进行的 Get 共享同一个 key 的加载，数据源不会被调用两次
+func TestGetMultiSharesLoads(t *testing.T) {
+	var mu sync.Mutex
+	loads := make(map[string]int)
+	started, release, batched := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	gee := NewGroup("multi-shared-scores", 2<<10, BatchGetterFunc(func(_ context.Context, keys []string) (map[string][]byte, error) {
+		mu.Lock()
+		for _, key := range keys {
+			loads[key]++
+		}
+		mu.Unlock()
+		switch {
+		case len(keys) == 1 && keys[0] == "Tom":
+			close(started)
+			<-release
+		case len(keys) == 1 && keys[0] == "Jack":
+			close(batched) // GetMulti 已经加入 Tom 的加载，只加载 Jack
+		}
+		values := make(map[string][]byte)
+		for _, key := range keys {
+			values[key] = []byte(db[key])
+		}
+		return values, nil
+	}))
+
+	got := make(chan ByteView)
+	go func() {
+		view, _ := gee.Get("Tom")
+		got <- view
+	}()
+	<-started
+	multi := make(chan map[string]ByteView)
+	go func() {
+		values, _ := gee.GetMulti([]string{"Tom", "Jack"})
+		multi <- values
+	}()
+	<-batched
+	close(release)
+	if view := <-got; view.String() != "630" {
+		t.Fatalf("expected Tom=630, got %s", view)
+	}
+	if values := <-multi; values["Tom"].String() != "630" || values["Jack"].String() != "589" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if loads["Tom"] != 1 || loads["Jack"] != 1 {
+		t.Fatalf("expected each key loaded once, got %v", loads)
+	}
+	if s := gee.Stats(); s.Loads != 3 || s.LoadsDeduped != 2 {
+		t.Fatalf("expected 3 loads deduped to 2, got %+v", s)
+	}
+}
+
+// 数据源不支持批量加载时，同时进行的加载数不超过 maxBatchLoads
+func TestGetMultiLimitsLoads(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+	gee := NewGroup("multi-limit-scores", 2<<20, GetterFunc(func(key string) ([]byte, error) {
+		mu.Lock()
+		if running++; running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return []byte(key), nil
+	}))
+	keys := make([]string, 10*maxBatchLoads)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	values, err := gee.GetMulti(keys)
+	if err != nil || len(values) != len(keys) {
+		t.Fatalf("expected %d values, got %d (%v)", len(keys), len(values), err)
+	}
+	if peak > maxBatchLoads {
+		t.Fatalf("%d loads ran at once, limit %d", peak, maxBatchLoads)
+	}
+}
+
+// fakeBatchPeer 支持批量请求，fail 中的 key 不返回结果
+type fakeBatchPeer struct {
+	fakePeer
+	batches [][]string
+	fail    map[string]bool
+}
+
+func (p *fakeBatchPeer) GetMulti(_ context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	p.batches = append(p.batches, in.GetKeys())
+	out.Values = make(map[string]*pb.Response)
+	for _, key := range in.GetKeys() {
+		if p.fail[key] {
+			continue
+		}
+		if v, ok := db[key]; ok {
+			out.Values[key] = &pb.Response{Value: []byte(v)}
+		} else {
+			out.NotFound = append(out.NotFound, key)
+		}
+	}
+	return nil
+}
+
+// ownerPicker 把 owned 中的 key 分配给对应的节点，其余 key 属于本节点
+type ownerPicker struct {
+	owned map[string]PeerGetter
+}
+
+func (p *ownerPicker) PickPeer(key string) (PeerGetter, bool) {
+	peer, ok := p.owned[key]
+	return peer, ok
+}
+
+func (p *ownerPicker) GetAll() []PeerGetter { return nil }
+
+func TestGetMultiPeers(t *testing.T) {
+	var localKeys []string
+	var mu sync.Mutex
+	gee := NewGroup("multi-peer-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			localKeys = append(localKeys, key)
+			mu.Unlock()
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}), WithHotCacheSampleRate(0))
+	batchPeer := &fakeBatchPeer{fail: map[string]bool{"Jack": true}}
+	plainPeer := &fakePeer{}
+	gee.RegisterPeers(&ownerPicker{owned: map[string]PeerGetter{
+		"Tom": batchPeer, "Jack": batchPeer, "unknown": batchPeer,
+		"Sam": plainPeer,
+	}})
+
+	values, err := gee.GetMulti([]string{"Tom", "Jack", "unknown", "Sam", "local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 3 || values["Tom"].String() != "630" || values["Jack"].String() != "589" || values["Sam"].String() != "567" {
+		t.Fatalf("unexpected values %v", values)
+	}
+	if len(batchPeer.batches) != 1 || len(batchPeer.batches[0]) != 3 {
+		t.Fatalf("expected one batch request of 3 keys, got %v", batchPeer.batches)
+	}
+	if plainPeer.gets != 1 {
+		t.Fatalf("peer without batch support should get one request, got %d", plainPeer.gets)
+	}
+	// 远程节点没有返回的 Jack 回退到本地加载，确认不存在的 unknown 不回退
+	sort.Strings(localKeys)
+	if len(localKeys) != 2 || localKeys[0] != "Jack" || localKeys[1] != "local" {
+		t.Fatalf("unexpected local loads %v", localKeys)
+	}
+}
+
+func TestHTTPGetMulti(t *testing.T) {
+	getter := &batchGetter{}
+	gee := NewGroup("http-multi-scores", 2<<10, BatchGetterFunc(getter.GetMulti))
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	out := &pb.BatchResponse{}
+	err := peer.GetMulti(context.Background(), &pb.BatchRequest{Group: gee.name, Keys: []string{"Tom", "Jack", "unknown"}}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.GetValues()) != 2 || string(out.GetValues()["Tom"].GetValue()) != "630" {
+		t.Fatalf("unexpected values %v", out.GetValues())
+	}
+	if len(out.GetNotFound()) != 1 || out.GetNotFound()[0] != "unknown" {
+		t.Fatalf("expected unknown not found, got %v", out.GetNotFound())
+	}
+	if len(getter.calls) != 1 {
+		t.Fatalf("expected one batch load on the server, got %v", getter.calls)
+	}
+
+	if err = peer.GetMulti(context.Background(), &pb.BatchRequest{Group: "no-such-group", Keys: []string{"Tom"}}, out); err == nil {
+		t.Fatal("expected error for unknown group")
+	}
+}
@@ -0,0 +1,177 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geecache/bloom"
+	"log"
+	"sync"
+	"time"
+)
+
+// KeyEnumerator 枚举数据源中的所有 key，对每个 key 调用 add，用来构建布隆过滤器
+type KeyEnumerator func(ctx context.Context, add func(key string)) error
+
+// bloomGuard 用布隆过滤器拦截一定不存在的 key，防止缓存穿透。
+// 过滤器构建完成之前不拦截任何 key。
+type bloomGuard struct {
+	expected  uint    // 预计的 key 数量
+	fpRate    float64 // 期望的误判率
+	enumerate KeyEnumerator
+	interval  time.Duration // 定期重建的间隔，0 表示不定期重建
+
+	rebuildMu sync.Mutex // 保证同一时间只有一个重建
+	mu        sync.RWMutex
+	filter    *bloom.Filter // 当前使用的过滤器，nil 表示尚未构建
+	building  *bloom.Filter // 正在重建的过滤器，重建期间新增的 key 同时加入
+}
+
+// WithBloomFilter 为 Group 加上布隆过滤器：过滤器认为一定不存在的 key 直接返回 ErrNotFound，
+// 不会进入 singleflight 或 Getter。过滤器由 enumerate 枚举数据源构建（RebuildBloomFilter），
+// 也可以从其他节点获取（FetchBloomFilter），加载或 Set 成功的 key 会被加入过滤器。
+// 要求所有节点的数据源相同，过滤器覆盖整个数据集而不只是本节点负责的 key。
+func WithBloomFilter(expectedKeys uint, fpRate float64, enumerate KeyEnumerator) GroupOption {
+	return func(g *Group) {
+		if g.bloom == nil {
+			g.bloom = &bloomGuard{}
+		}
+		g.bloom.expected = expectedKeys
+		g.bloom.fpRate = fpRate
+		g.bloom.enumerate = enumerate
+	}
+}
+
+// WithBloomRebuild 每隔 interval 重新枚举数据源构建布隆过滤器，清除已经删除的 key 并控制误判率，
+// 需要与 WithBloomFilter 一起使用
+func WithBloomRebuild(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		if g.bloom == nil {
+			g.bloom = &bloomGuard{}
+		}
+		g.bloom.interval = interval
+	}
+}
+
+// mayContain 判断 key 是否可能存在，没有过滤器时总是返回 true
+func (b *bloomGuard) mayContain(key string) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.RLock()
+	f := b.filter
+	b.mu.RUnlock()
+	return f == nil || f.Test(key)
+}
+
+// add 把确认存在的 key 加入过滤器
+func (b *bloomGuard) add(key string) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.filter != nil {
+		b.filter.Add(key)
+	}
+	if b.building != nil {
+		b.building.Add(key)
+	}
+}
+
+func (b *bloomGuard) rebuild(ctx context.Context) error {
+	if b.enumerate == nil {
+		return errors.New("geecache: no key enumerator for bloom filter")
+	}
+	b.rebuildMu.Lock()
+	defer b.rebuildMu.Unlock()
+
+	f := bloom.New(b.expected, b.fpRate)
+	b.mu.Lock()
+	b.building = f
+	b.mu.Unlock()
+
+	err := b.enumerate(ctx, f.Add)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.building = nil
+	if err != nil {
+		return err
+	}
+	b.filter = f
+	return nil
+}
+
+func (b *bloomGuard) install(f *bloom.Filter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.filter = f
+}
+
+func (b *bloomGuard) marshal() ([]byte, bool) {
+	b.mu.RLock()
+	f := b.filter
+	b.mu.RUnlock()
+	if f == nil {
+		return nil, false
+	}
+	data, _ := f.MarshalBinary()
+	return data, true
+}
+
+// rebuildLoop 定期重建过滤器
+func (g *Group) rebuildLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := g.bloom.rebuild(context.Background()); err != nil {
+			log.Printf("[GeeCache] rebuild bloom filter of %s failed: %v", g.name, err)
+		}
+	}
+}
+
+// RebuildBloomFilter 枚举数据源重新构建布隆过滤器，完成后替换当前的过滤器
+func (g *Group) RebuildBloomFilter(ctx context.Context) error {
+	if g.bloom == nil {
+		return errors.New("geecache: bloom filter is not enabled")
+	}
+	return g.bloom.rebuild(ctx)
+}
+
+// BloomFilter 返回序列化后的布隆过滤器，过滤器还没有构建时 ok 为 false
+func (g *Group) BloomFilter() (data []byte, ok bool) {
+	if g.bloom == nil {
+		return nil, false
+	}
+	return g.bloom.marshal()
+}
+
+// FetchBloomFilter 从其他节点获取布隆过滤器，节点启动时可以用它代替枚举数据源。
+// 依次尝试实现了 BloomFetcher 的节点，使用第一个成功的结果。
+func (g *Group) FetchBloomFilter(ctx context.Context) error {
+	if g.bloom == nil {
+		return errors.New("geecache: bloom filter is not enabled")
+	}
+	if g.peers == nil {
+		return errors.New("geecache: no peers to fetch bloom filter from")
+	}
+	err := errors.New("geecache: no peer serves bloom filters")
+	for _, peer := range g.peers.GetAll() {
+		fetcher, ok := peer.(BloomFetcher)
+		if !ok {
+			continue
+		}
+		var data []byte
+		if data, err = fetcher.GetBloomFilter(ctx, g.name); err != nil {
+			continue
+		}
+		f := &bloom.Filter{}
+		if err = f.UnmarshalBinary(data); err != nil {
+			continue
+		}
+		g.bloom.install(f)
+		return nil
+	}
+	return fmt.Errorf("fetch bloom filter of %s: %v", g.name, err)
+}
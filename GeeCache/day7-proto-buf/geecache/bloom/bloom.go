@@ -0,0 +1,124 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync/atomic"
+)
+
+// Filter 是布隆过滤器：Test 返回 false 时 key 一定没有被添加过，返回 true 时可能被添加过。
+// Add 和 Test 可以并发调用。
+type Filter struct {
+	m    uint64   // 位数组的长度
+	k    uint64   // 每个 key 设置的位数，即哈希函数的个数
+	bits []uint64 // 位数组，按 64 位一组存放
+	n    uint64   // 添加过的 key 的个数（包括重复添加）
+}
+
+// New 创建一个布隆过滤器，预计添加 n 个 key 时误判率约为 fpRate
+func New(n uint, fpRate float64) *Filter {
+	if n == 0 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+	// m = -n*ln(p) / (ln2)^2，k = m/n * ln2
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return newFilter(m, k)
+}
+
+func newFilter(m, k uint64) *Filter {
+	words := (m + 63) / 64
+	return &Filter{m: words * 64, k: k, bits: make([]uint64, words)}
+}
+
+// hashes 使用双重哈希 g_i = h1 + i*h2 模拟 k 个哈希函数
+func hashes(key string) (h1, h2 uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h1 = h.Sum64()
+	// splitmix64 打散 h1 得到第二个哈希值，保证为奇数以覆盖所有位置
+	h2 = h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 = (h2 ^ (h2 >> 31)) | 1
+	return
+}
+
+// Add 添加一个 key
+func (f *Filter) Add(key string) {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		pos := (h1 + i*h2) % f.m
+		word, mask := &f.bits[pos/64], uint64(1)<<(pos%64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+	atomic.AddUint64(&f.n, 1)
+}
+
+// Test 判断 key 是否可能被添加过
+func (f *Filter) Test(key string) bool {
+	h1, h2 := hashes(key)
+	for i := uint64(0); i < f.k; i++ {
+		pos := (h1 + i*h2) % f.m
+		if atomic.LoadUint64(&f.bits[pos/64])&(uint64(1)<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Count 返回添加过的 key 的个数（包括重复添加）
+func (f *Filter) Count() uint64 {
+	return atomic.LoadUint64(&f.n)
+}
+
+// 序列化格式：版本(1 字节) + m、k、n(各 8 字节，大端) + 位数组
+const (
+	version    = 1
+	headerSize = 1 + 8*3
+)
+
+// MarshalBinary 实现了 encoding.BinaryMarshaler，用来把过滤器发给其他节点
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	data := make([]byte, headerSize+8*len(f.bits))
+	data[0] = version
+	binary.BigEndian.PutUint64(data[1:], f.m)
+	binary.BigEndian.PutUint64(data[9:], f.k)
+	binary.BigEndian.PutUint64(data[17:], f.Count())
+	for i := range f.bits {
+		binary.BigEndian.PutUint64(data[headerSize+8*i:], atomic.LoadUint64(&f.bits[i]))
+	}
+	return data, nil
+}
+
+// UnmarshalBinary 实现了 encoding.BinaryUnmarshaler，f 原有的内容会被替换
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerSize || data[0] != version {
+		return errors.New("bloom: invalid encoding")
+	}
+	m := binary.BigEndian.Uint64(data[1:])
+	k := binary.BigEndian.Uint64(data[9:])
+	if m == 0 || m%64 != 0 || k == 0 || uint64(len(data)-headerSize) != m/8 {
+		return errors.New("bloom: invalid encoding")
+	}
+	f.m, f.k = m, k
+	f.n = binary.BigEndian.Uint64(data[17:])
+	f.bits = make([]uint64, m/64)
+	for i := range f.bits {
+		f.bits[i] = binary.BigEndian.Uint64(data[headerSize+8*i:])
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package bloom
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+	for i := 0; i < n; i++ {
+		f.Add(strconv.Itoa(i))
+	}
+	for i := 0; i < n; i++ { // 添加过的 key 一定返回 true
+		if !f.Test(strconv.Itoa(i)) {
+			t.Fatalf("false negative for %d", i)
+		}
+	}
+
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if f.Test(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / n; rate > 0.02 {
+		t.Fatalf("false positive rate %.4f, expected about 0.01", rate)
+	}
+	if f.Count() != n {
+		t.Fatalf("expected count %d, got %d", n, f.Count())
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := New(100, 0.01)
+	f.Add("Tom")
+	f.Add("Jack")
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var g Filter
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Test("Tom") || !g.Test("Jack") || g.Count() != 2 {
+		t.Fatal("unmarshaled filter lost keys")
+	}
+	if g.Test("Sam") != f.Test("Sam") {
+		t.Fatal("unmarshaled filter differs from the original")
+	}
+
+	if err = g.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("expected error for truncated data")
+	}
+}
+
+func TestConcurrentAdd(t *testing.T) {
+	f := New(1000, 0.01)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < 1000; i += 4 {
+				f.Add(strconv.Itoa(i))
+				f.Test(strconv.Itoa(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i := 0; i < 1000; i++ {
+		if !f.Test(strconv.Itoa(i)) {
+			t.Fatalf("false negative for %d", i)
+		}
+	}
+}
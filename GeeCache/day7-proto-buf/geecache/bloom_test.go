@@ -0,0 +1,106 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	pb "geecache/geecachepb"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	loads := 0
+	gee := NewGroup("bloom-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}), WithBloomFilter(100, 0.01, func(ctx context.Context, add func(key string)) error {
+		for key := range db {
+			add(key)
+		}
+		return nil
+	}))
+
+	// 过滤器构建之前不拦截
+	if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) || loads != 1 {
+		t.Fatalf("expected load before the filter is built, loads %d", loads)
+	}
+
+	if err := gee.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) || loads != 1 {
+		t.Fatalf("bloom filter should reject unknown, loads %d", loads)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" || loads != 2 {
+		t.Fatalf("bloom filter should pass Tom, loads %d", loads)
+	}
+	if stats := gee.Stats(); stats.BloomRejects != 1 {
+		t.Fatalf("expected 1 bloom reject, got %+v", stats)
+	}
+
+	// Set 的 key 被加入过滤器
+	if err := gee.Set("new", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	gee.Invalidate("new")
+	if _, err := gee.Get("new"); errors.Is(err, ErrNotFound) && loads == 2 {
+		t.Fatal("key set after the rebuild should pass the filter")
+	}
+
+	// 从其他节点获取过滤器
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	before, _ := gee.BloomFilter()
+	pool := NewHTTPPool("self")
+	pool.Set(server.URL)
+	gee.RegisterPeers(pool)
+	if err := gee.FetchBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := gee.BloomFilter(); string(after) != string(before) || gee.bloom.mayContain("unknown") {
+		t.Fatal("fetched bloom filter differs from the peer's")
+	}
+}
+
+// 其他节点 Set 之后发来的删除通知让本节点的过滤器也知道这个 key
+func TestBloomFilterPeerSet(t *testing.T) {
+	gee := NewGroup("bloom-peer-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("v"), nil
+		}), WithBloomFilter(100, 0.01, func(ctx context.Context, add func(key string)) error {
+		return nil
+	}))
+	if err := gee.RebuildBloomFilter(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gee.Get("written"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the empty filter to reject written, got %v", err)
+	}
+
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	if err := getter.Remove(context.Background(), &pb.Request{Group: gee.name, Key: "written", Hops: 1}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get("written"); err != nil || view.String() != "v" {
+		t.Fatalf("expected written to pass the filter after the peer's notice, got %q, %v", view, err)
+	}
+}
+
+func TestBloomFilterDisabled(t *testing.T) {
+	gee := NewGroup("no-bloom-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	if err := gee.RebuildBloomFilter(context.Background()); err == nil {
+		t.Fatal("expected error without bloom filter")
+	}
+	if _, ok := gee.BloomFilter(); ok {
+		t.Fatal("group without bloom filter should not serve one")
+	}
+}
@@ -0,0 +1,155 @@
+package geecache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errBreakerOpen 表示远程节点的熔断器处于打开状态，请求没有发出
+var errBreakerOpen = errors.New("geecache: peer circuit breaker is open")
+
+// BreakerState 是熔断器的状态
+type BreakerState int
+
+const (
+	// BreakerClosed 正常状态，请求都会发往远程节点
+	BreakerClosed BreakerState = iota
+	// BreakerOpen 连续失败次数达到阈值，冷却时间内不再向该节点路由请求
+	BreakerOpen
+	// BreakerHalfOpen 冷却时间已过，只放行一个探测请求，成功则关闭，失败则重新打开
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// MarshalText 让状态在 JSON 中以字符串形式出现
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// breaker 是每个远程节点一个的熔断器。
+// threshold <= 0 时熔断器不起作用，总是放行请求。
+type breaker struct {
+	threshold int           // 连续失败多少次后打开
+	cooldown  time.Duration // 打开后多久进入半开状态
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int       // 连续失败次数
+	openedAt time.Time // 最近一次打开的时间
+	probing  bool      // 半开状态下是否已经有探测请求在进行
+	trips    int64     // 打开的总次数
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// ready 报告现在是否可以向该节点路由请求，不改变熔断器的状态，供 PickPeer 使用
+func (b *breaker) ready() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return time.Since(b.openedAt) >= b.cooldown
+	case BreakerHalfOpen:
+		return !b.probing
+	}
+	return true
+}
+
+// allow 在发出请求前调用，返回 false 时不应发出请求。
+// 返回 true 后必须调用 success、failure 或 cancel 之一报告结果。
+func (b *breaker) allow() bool {
+	if b == nil || b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// success 报告请求成功，熔断器回到关闭状态
+func (b *breaker) success() {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// failure 报告请求失败，连续失败达到阈值或探测失败时打开熔断器
+func (b *breaker) failure() {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+		b.trips++
+	}
+	b.probing = false
+}
+
+// cancel 报告请求被调用方放弃，结果不计入成功或失败
+func (b *breaker) cancel() {
+	if b == nil || b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// BreakerStats 是熔断器状态的快照
+type BreakerStats struct {
+	State    BreakerState `json:"state"`
+	Failures int          `json:"consecutive_failures"`
+	Trips    int64        `json:"trips"`
+}
+
+func (b *breaker) stats() BreakerStats {
+	if b == nil {
+		return BreakerStats{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state := b.state
+	if state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		state = BreakerHalfOpen // 冷却时间已过，下一个请求就是探测请求
+	}
+	return BreakerStats{State: state, Failures: b.failures, Trips: b.trips}
+}
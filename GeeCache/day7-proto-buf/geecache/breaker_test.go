@@ -0,0 +1,142 @@
+package geecache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "geecache/geecachepb"
+)
+
+func TestBreaker(t *testing.T) {
+	b := newBreaker(2, 20*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("closed breaker should allow request %d", i)
+		}
+		b.failure()
+	}
+	if s := b.stats(); s.State != BreakerOpen || s.Trips != 1 {
+		t.Fatalf("breaker should open after 2 failures, got %+v", s)
+	}
+	if b.ready() || b.allow() {
+		t.Fatal("open breaker should reject requests")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.ready() || !b.allow() {
+		t.Fatal("breaker should allow a probe after cooldown")
+	}
+	if b.ready() || b.allow() {
+		t.Fatal("half-open breaker should allow only one probe")
+	}
+	b.failure()
+	if s := b.stats(); s.State != BreakerOpen || s.Trips != 2 {
+		t.Fatalf("failed probe should reopen the breaker, got %+v", s)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("breaker should allow a probe after cooldown")
+	}
+	b.cancel() // 放弃的探测请求不影响状态，下一个请求可以继续探测
+	if !b.allow() {
+		t.Fatal("canceled probe should release the half-open slot")
+	}
+	b.success()
+	if s := b.stats(); s.State != BreakerClosed || s.Failures != 0 {
+		t.Fatalf("successful probe should close the breaker, got %+v", s)
+	}
+}
+
+// 测试 503 会被重试，重试仍然失败后熔断器打开，PickPeer 不再选择该节点
+func TestHTTPPoolRetryAndBreaker(t *testing.T) {
+	gee := NewGroup("breaker-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+
+	var hits int32
+	peerPool := NewHTTPPool("")
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := atomic.AddInt32(&hits, 1); n == 1 || n > 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		peerPool.ServeHTTP(w, r)
+	}))
+	defer peer.Close()
+
+	pool := NewHTTPPool("self", WithRetries(1, time.Millisecond), WithCircuitBreaker(2, time.Hour))
+	pool.Set(peer.URL)
+	getter, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatal("failed to pick peer")
+	}
+
+	// 第一次请求返回 503，重试后成功
+	out := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: "Tom"}, out); err != nil || string(out.GetValue()) != "630" {
+		t.Fatalf("retry should succeed, err %v", err)
+	}
+
+	// 之后一直返回 503，两次请求失败后熔断
+	for i := 0; i < 2; i++ {
+		if err := getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: "Tom"}, out); err == nil {
+			t.Fatal("expected request to fail")
+		}
+	}
+	if _, ok := pool.PickPeer("Tom"); ok {
+		t.Fatal("PickPeer should skip a peer with an open breaker")
+	}
+	if err := getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: "Tom"}, out); err != errBreakerOpen {
+		t.Fatalf("expected errBreakerOpen, got %v", err)
+	}
+
+	stats := pool.PeerStats()[peer.URL]
+	if stats.Requests != 3 || stats.Retries != 3 || stats.Errors != 2 || stats.Breaker.State != BreakerOpen {
+		t.Fatalf("unexpected peer stats %+v", stats)
+	}
+
+	server := httptest.NewServer(pool)
+	defer server.Close()
+	res, err := http.Get(server.URL + defaultBasePath + peersPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var all map[string]struct {
+		Breaker struct {
+			State string `json:"state"`
+		} `json:"breaker"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&all); err != nil || all[peer.URL].Breaker.State != "open" {
+		t.Fatalf("unexpected peers endpoint response %+v, err %v", all, err)
+	}
+}
+
+// 测试每次请求的超时时间
+func TestHTTPPoolTimeout(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer peer.Close()
+
+	pool := NewHTTPPool("self", WithPeerTimeout(10*time.Millisecond), WithRetries(0, 0))
+	pool.Set(peer.URL)
+	getter, _ := pool.PickPeer("Tom")
+	start := time.Now()
+	if err := getter.Get(context.Background(), &pb.Request{Group: "scores", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatal("expected timeout")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("request took %v, timeout not applied", d)
+	}
+	if s := pool.PeerStats()[peer.URL]; s.Errors != 1 || s.Breaker.Failures != 1 {
+		t.Fatalf("timeout should count as a peer failure, got %+v", s)
+	}
+}
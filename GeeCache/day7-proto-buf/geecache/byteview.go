@@ -1,9 +1,24 @@
 package geecache
 
+import "time"
+
 // A ByteView holds an immutable view of bytes.
 // 抽象一个只读数据结构 ByteView 用来表示缓存值
 type ByteView struct {
 	b []byte // b 将会存储真实的缓存值, 选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等
+	e time.Time // e 是缓存值的过期时间，零值表示永不过期
+	s time.Time // s 是提前刷新模式下的软过期时间，过了之后仍然可以返回，但会在后台重新加载
+	d time.Duration // d 是加载这个值花费的时间，用于概率性提前刷新
+}
+
+// Expire 方法 返回缓存值的过期时间，零值表示永不过期
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
+// RefreshAt 方法 返回缓存值的软过期时间，零值表示不会提前刷新
+func (v ByteView) RefreshAt() time.Time {
+	return v.s
 }
 
 // Len 方法 返回ByteView的长度
@@ -3,37 +3,197 @@ package geecache
 import (
 	"geecache/lru"
 	"sync"
+	"time"
 )
 
-// cache.go 的实现非常简单，实例化 lru，封装 get 和 add 方法，
+// 每个分片清理过期记录的间隔
+const defaultPurgeInterval = time.Minute
+
+// cache.go 的实现非常简单，实例化淘汰策略（默认是 lru），封装 get 和 add 方法，
 // 并添加互斥锁 mu。
+// 为了减少锁竞争，缓存按 key 的哈希值分成 shardCount 个分片，每个分片有自己的锁和
+// 淘汰策略，cacheBytes 平均分给各个分片。
 type cache struct {
-	mu sync.Mutex
-	lru *lru.Cache
+	newPolicy PolicyFunc // 创建淘汰策略，为 nil 时使用 LRU
 	cacheBytes int64
+	shardCount int // 分片数，<= 0 时为 1
+	initOnce sync.Once
+	shards []*cacheShard
+	nget, nhit, nevict AtomicInt // 统计计数
+}
+
+// cacheShard 是 cache 的一个分片
+type cacheShard struct {
+	mu sync.Mutex
+	policy Policy
+	nextPurge time.Time // 下一次清理过期记录的时间，由 mu 保护
+}
+
+// CacheStats 是某个缓存的统计信息
+type CacheStats struct {
+	Bytes     int64 `json:"bytes"`     // 已使用的内存
+	Items     int64 `json:"items"`     // 缓存的条数
+	Gets      int64 `json:"gets"`      // 查询次数
+	Hits      int64 `json:"hits"`      // 命中次数
+	Evictions int64 `json:"evictions"` // 因容量或过期被淘汰的条数
+}
+
+func (c *cache) stats() CacheStats {
+	s := CacheStats{
+		Gets:      c.nget.Get(),
+		Hits:      c.nhit.Get(),
+		Evictions: c.nevict.Get(),
+	}
+	for _, shard := range c.getShards() {
+		shard.mu.Lock()
+		s.Bytes += shard.policy.Bytes()
+		s.Items += int64(shard.policy.Len())
+		shard.mu.Unlock()
+	}
+	return s
+}
+
+// getShards 返回所有分片，第一次调用时才创建
+// 延迟初始化(Lazy Initialization)，一个对象的延迟初始化意味着该对象的创建
+// 将会延迟至第一次使用该对象时。主要用于提高性能，并减少程序内存要求。
+func (c *cache) getShards() []*cacheShard {
+	c.initOnce.Do(func() {
+		n := c.shardCount
+		if n <= 0 {
+			n = 1
+		}
+		// 每个分片至少分到 1 字节，否则 cacheBytes/n 为 0，分片会变成不限制
+		if c.cacheBytes > 0 && int64(n) > c.cacheBytes {
+			n = int(c.cacheBytes)
+		}
+		newPolicy := c.newPolicy
+		if newPolicy == nil {
+			newPolicy = LRU
+		}
+		onEvicted := func(key string, value ByteView, reason lru.EvictReason) {
+			if reason != lru.EvictRemoved { // 主动删除不算淘汰
+				c.nevict.Add(1)
+			}
+		}
+		shards := make([]*cacheShard, n)
+		for i := range shards {
+			// cacheBytes 为 0 表示不限制，每个分片同样不限制
+			shards[i] = &cacheShard{policy: newPolicy(c.cacheBytes/int64(n), onEvicted)}
+		}
+		c.shards = shards
+	})
+	return c.shards
+}
+
+// shard 根据 key 的 FNV-1a 哈希值选择分片
+func (c *cache) shard(key string) *cacheShard {
+	shards := c.getShards()
+	if len(shards) == 1 {
+		return shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return shards[h%uint32(len(shards))]
 }
 
 func (c *cache) add(key string, value ByteView) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	// 判断了 c.lru 是否为 nil，如果等于 nil 再创建实例
-	// 延迟初始化(Lazy Initialization)，一个对象的延迟初始化意味着该对象的创建
-	// 将会延迟至第一次使用该对象时。主要用于提高性能，并减少程序内存要求。
-	if c.lru == nil {
-		c.lru = lru.New(c.cacheBytes, nil)
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	c.addLocked(shard, key, value)
+}
+
+// addLocked 把 value 加入分片，调用方需持有 shard.mu
+func (c *cache) addLocked(shard *cacheShard, key string, value ByteView) {
+	c.purgeLocked(shard)
+	if value.e.IsZero() {
+		shard.policy.Add(key, value)
+		return
+	}
+	ttl := time.Until(value.e)
+	if ttl <= 0 { // 已经过期的值没有必要缓存
+		return
 	}
-	c.lru.Add(key, value)
+	shard.policy.AddWithTTL(key, value, ttl)
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if c.lru == nil {
+// purgeLocked 每隔 defaultPurgeInterval 清理一次分片中的过期记录。Get 只会惰性删除被访问到的
+// 过期记录，不再被访问的过期记录在写入时顺带回收，不需要后台协程。调用方需持有 shard.mu
+func (c *cache) purgeLocked(shard *cacheShard) {
+	now := time.Now()
+	if now.Before(shard.nextPurge) {
 		return
 	}
+	shard.nextPurge = now.Add(defaultPurgeInterval)
+	shard.policy.RemoveExpired()
+}
 
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+func (c *cache) get(key string) (value ByteView, ok bool) {
+	c.nget.Add(1)
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if v, ok := shard.policy.Get(key); ok {
+		c.nhit.Add(1)
+		return v, ok
 	}
 	return
-}
\ No newline at end of file
+}
+
+func (c *cache) remove(key string) {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.policy.Remove(key)
+}
+
+// addIfAbsent 只在 key 不存在时添加，返回是否添加。检查和添加在同一次加锁中完成，
+// 不会覆盖在两者之间由 Set 或加载写入的新值
+func (c *cache) addIfAbsent(key string, value ByteView) bool {
+	shard := c.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if _, ok := shard.policy.Get(key); ok {
+		return false
+	}
+	c.addLocked(shard, key, value)
+	return true
+}
+
+// cacheEntry 是从缓存中取出的一条记录
+type cacheEntry struct {
+	key   string
+	value ByteView
+}
+
+// collect 返回 match 为 true 的未过期记录。每个分片按淘汰策略的顺序（最应保留的在前）遍历，
+// 总共最多取 limit 条，limit <= 0 表示不限制。淘汰策略没有实现 RangePolicy 时返回 nil。
+func (c *cache) collect(match func(key string) bool, limit int) []cacheEntry {
+	shards := c.getShards()
+	perShard := 0 // 每个分片最多取的条数，避免只取到前几个分片的记录
+	if limit > 0 {
+		perShard = (limit + len(shards) - 1) / len(shards)
+	}
+	var entries []cacheEntry
+	for _, shard := range shards {
+		shard.mu.Lock()
+		if p, ok := shard.policy.(RangePolicy); ok {
+			n := 0
+			p.Range(func(key string, value ByteView) bool {
+				if match(key) {
+					entries = append(entries, cacheEntry{key, value})
+					n++
+				}
+				return perShard == 0 || n < perShard
+			})
+		}
+		shard.mu.Unlock()
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
@@ -0,0 +1,122 @@
+package geecache
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheShards(t *testing.T) {
+	c := &cache{cacheBytes: 1 << 10, shardCount: 4}
+	if shards := c.getShards(); len(shards) != 4 {
+		t.Fatalf("expected 4 shards, got %d", len(shards))
+	}
+
+	// 同一个 key 总是落在同一个分片
+	if c.shard("Tom") != c.shard("Tom") {
+		t.Fatal("key should always map to the same shard")
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		c.add(key, ByteView{b: []byte("value")})
+		if v, ok := c.get(key); !ok || v.String() != "value" {
+			t.Fatalf("failed to get %s right after add", key)
+		}
+	}
+
+	// 每个分片的内存上限是 cacheBytes / 4，总量不会超过 cacheBytes
+	used := 0
+	for _, shard := range c.getShards() {
+		if b := shard.policy.Bytes(); b > 1<<10/4 {
+			t.Fatalf("shard uses %d bytes, more than its budget", b)
+		} else if b > 0 {
+			used++
+		}
+	}
+	if used != 4 {
+		t.Fatalf("expected keys spread over all shards, %d used", used)
+	}
+
+	s := c.stats()
+	if s.Bytes > 1<<10 || s.Gets != 1000 || s.Hits != 1000 || s.Evictions == 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	c.remove("999")
+	if _, ok := c.get("999"); ok {
+		t.Fatal("999 should be removed")
+	}
+}
+
+// cacheBytes 小于分片数时减少分片，总量仍然不超过 cacheBytes
+func TestCacheShardsSmallBudget(t *testing.T) {
+	c := &cache{cacheBytes: 100, shardCount: 128}
+	if shards := c.getShards(); len(shards) != 100 {
+		t.Fatalf("expected 100 shards, got %d", len(shards))
+	}
+	for i := 0; i < 1000; i++ {
+		c.add(strconv.Itoa(i), ByteView{b: []byte("v")})
+	}
+	if s := c.stats(); s.Bytes > 100 {
+		t.Fatalf("cache uses %d bytes, more than cacheBytes", s.Bytes)
+	}
+}
+
+// addIfAbsent 不覆盖已经存在的值
+func TestCacheAddIfAbsent(t *testing.T) {
+	c := &cache{cacheBytes: 1 << 10, shardCount: 4}
+	if !c.addIfAbsent("Tom", ByteView{b: []byte("old")}) {
+		t.Fatal("expected Tom added")
+	}
+	c.add("Tom", ByteView{b: []byte("new")})
+	if c.addIfAbsent("Tom", ByteView{b: []byte("old")}) {
+		t.Fatal("addIfAbsent should not replace an existing value")
+	}
+	if v, ok := c.get("Tom"); !ok || v.String() != "new" {
+		t.Fatalf("expected Tom=new, got %s", v)
+	}
+}
+
+// 写入时定期清理分片中没有再被访问的过期记录
+func TestCachePurgeOnAdd(t *testing.T) {
+	c := &cache{cacheBytes: 1 << 10}
+	c.add("old", ByteView{b: []byte("v"), e: time.Now().Add(time.Millisecond)})
+	time.Sleep(2 * time.Millisecond)
+	c.add("new", ByteView{b: []byte("v")})
+	if s := c.stats(); s.Items != 2 {
+		t.Fatalf("expected no purge within the interval, got %d items", s.Items)
+	}
+
+	shard := c.shard("new")
+	shard.nextPurge = time.Time{} // 到了下一次清理的时间
+	c.add("new", ByteView{b: []byte("v")})
+	if s := c.stats(); s.Items != 1 || s.Evictions != 1 {
+		t.Fatalf("expected the expired entry purged, got %+v", s)
+	}
+}
+
+// BenchmarkCacheGet 比较不同分片数下并发读的吞吐量，
+// 使用 go test -bench CacheGet -cpu 1,2,4,8 观察随 GOMAXPROCS 的变化
+func BenchmarkCacheGet(b *testing.B) {
+	const keys = 1 << 12
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := &cache{shardCount: shards}
+			names := make([]string, keys)
+			for i := range names {
+				names[i] = strconv.Itoa(i)
+				c.add(names[i], ByteView{b: []byte("value")})
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					c.get(names[i&(keys-1)])
+					i++
+				}
+			})
+		})
+	}
+}
@@ -1,80 +1,306 @@
 package consistenthash
 
 import (
-	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
 
 // Hash 映射到 uint32 (0~2^32-1)
 // 定义了函数类型 Hash，采取依赖注入的方式，允许用于替换成自定义的 Hash 函数，
-// 也方便测试时替换，默认为 crc32.ChecksumIEEE 算法。
+// 也方便测试时替换。哈希环是 64 位的，32 位的哈希值只占用环的前 2^32 个位置。
 type Hash func(data []byte) uint32
 
+// Option 是 New 的可选配置
+type Option func(*Map)
+
+// WithHash64 使用 64 位的哈希函数，覆盖 New 传入的 fn
+func WithHash64(fn Hash64) Option {
+	return func(m *Map) {
+		if fn != nil {
+			m.hash = fn
+		}
+	}
+}
+
+// LoadFunc 返回真实节点当前的负载，例如正在处理的请求数
+type LoadFunc func(node string) int64
+
+// vnode 是哈希环上的一个虚拟节点
+type vnode struct {
+	hash uint64 // 虚拟节点的哈希值
+	node string // 对应的真实节点
+}
+
 // Map 是一致性哈希算法的主数据结构
 // Map 包含所有哈希键
 type Map struct {
-	hash Hash // Hash 函数 hash
-	replicas int // 虚拟节点倍数 replicas
-	keys []int // Sorted 哈希环 keys
-	hashMap map[int]string // 虚拟节点与真实节点的映射表 hashMap, 键是虚拟节点的哈希值，值是真实节点的名称。
+	hash     Hash64         // Hash 函数 hash
+	replicas int            // 虚拟节点倍数 replicas
+	ring     []vnode        // 哈希环，按哈希值排序，哈希值相同时按真实节点名称排序
+	weights  map[string]int // 真实节点的权重，虚拟节点数是 replicas * weight
+
+	epsilon float64  // 有界负载模式下允许超出平均负载的比例，0 表示关闭
+	load    LoadFunc // 有界负载模式下查询节点负载
 }
 
 // New 创建 Map实例
-// 构造函数 New() 允许自定义虚拟节点倍数和 Hash 函数
-func New(replicas int, fn Hash) *Map {
+// 构造函数 New() 允许自定义虚拟节点倍数和 32 位的 Hash 函数，
+// fn 为 nil 且没有使用 WithHash64 时，默认为 FNV-1a 64 位哈希。
+func New(replicas int, fn Hash, opts ...Option) *Map {
 	m := &Map{
 		replicas: replicas,
-		hash: fn,
-		hashMap: make(map[int]string),
+		hash:     defaultHash64,
+		weights:  make(map[string]int),
 	}
-
-	if m.hash == nil {
-		m.hash = crc32.ChecksumIEEE
+	if fn != nil {
+		m.hash = func(data []byte) uint64 { return uint64(fn(data)) }
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
 	return m
 }
 
 // 添加真实节点/机器的 Add() 方法
+// 已经存在的节点会被忽略。
 func (m *Map) Add(keys ...string) { // Add 函数允许传入 0 或 多个真实节点的名称。
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ { // 对每一个真实节点 key，对应创建 m.replicas 个虚拟节点
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key))) // 通过添加编号的方式区分不同虚拟节点。
-			// 使用 m.hash() 计算虚拟节点的哈希值
-			m.keys = append(m.keys, hash) // 使用 append(m.keys, hash) 添加到环上。
-			m.hashMap[hash] = key // 在 hashMap 中增加虚拟节点和真实节点的映射关系
+		if _, ok := m.weights[key]; !ok {
+			m.addNode(key, 1)
 		}
 	}
-	sort.Ints(m.keys) // 环上的哈希值排序
+	m.sort()
+}
+
+// AddWeighted 添加一个权重为 weight 的真实节点，它的虚拟节点数是 replicas * weight，
+// 分到的 key 大致与权重成正比。权重为 1 时与 Add 相同，weight <= 0 时不添加，
+// 已经存在的节点更新权重。
+func (m *Map) AddWeighted(key string, weight int) {
+	if weight <= 0 || m.weights[key] == weight {
+		return
+	}
+	if _, ok := m.weights[key]; ok {
+		m.Remove(key)
+	}
+	m.addNode(key, weight)
+	m.sort()
+}
+
+// addNode 把节点的虚拟节点加入环中，调用方负责排序
+func (m *Map) addNode(key string, weight int) {
+	for i := 0; i < m.replicas*weight; i++ { // 对每一个真实节点 key，对应创建 m.replicas * weight 个虚拟节点
+		hash := m.hash([]byte(strconv.Itoa(i) + key)) // 通过添加编号的方式区分不同虚拟节点。
+		// 使用 m.hash() 计算虚拟节点的哈希值，添加到环上。
+		// 不同节点的虚拟节点哈希值可能相同，它们都保留在环上，互不覆盖
+		m.ring = append(m.ring, vnode{hash: hash, node: key})
+	}
+	m.weights[key] = weight
+}
+
+// sort 按哈希值排序，哈希值相同的虚拟节点按真实节点名称排序，
+// 冲突的位置总是属于名称最小的节点，与添加顺序无关，每个实例得到的环都相同
+func (m *Map) sort() {
+	sort.Slice(m.ring, func(i, j int) bool {
+		a, b := m.ring[i], m.ring[j]
+		return a.hash < b.hash || a.hash == b.hash && a.node < b.node
+	})
+}
+
+// Hash 返回 key 在哈希环上的位置，Ranges 和 Moved 中的区间都以它为准
+func (m *Map) Hash(key string) uint64 {
+	return m.hash([]byte(key))
+}
+
+// search 返回顺时针方向第一个哈希值 >= hash 的虚拟节点的下标，可能等于 len(m.ring)
+func (m *Map) search(hash uint64) int {
+	// 二分查找合适的虚拟节点
+	return sort.Search(len(m.ring), func(i int) bool {
+		return m.ring[i].hash >= hash
+	})
+}
+
+// SetBoundedLoads 开启有界负载模式（consistent hashing with bounded loads）：
+// 节点的负载超过 (1+epsilon) 倍平均负载（按权重折算）时，Get 跳过它，继续顺时针寻找下一个节点，
+// 避免少数热点 key 把一个节点压垮。load 返回节点当前的负载，由调用方统计。
+// epsilon <= 0 或 load 为 nil 时关闭。Ranges 不受影响，仍然反映不考虑负载时的归属。
+func (m *Map) SetBoundedLoads(epsilon float64, load LoadFunc) {
+	if epsilon <= 0 || load == nil {
+		m.epsilon, m.load = 0, nil
+		return
+	}
+	m.epsilon, m.load = epsilon, load
+}
+
+// capacities 返回有界负载模式下每个节点的负载上限，以及节点当前的负载。
+// 上限为 ceil((1+epsilon) * (总负载+1) * 权重 / 总权重)，+1 是即将分配的这个请求。
+func (m *Map) capacities() (capacity, loads map[string]int64) {
+	var total int64
+	totalWeight := 0
+	loads = make(map[string]int64, len(m.weights))
+	for node, weight := range m.weights {
+		loads[node] = m.load(node)
+		total += loads[node]
+		totalWeight += weight
+	}
+	capacity = make(map[string]int64, len(m.weights))
+	for node, weight := range m.weights {
+		capacity[node] = int64(math.Ceil((1 + m.epsilon) * float64(total+1) * float64(weight) / float64(totalWeight)))
+	}
+	return capacity, loads
 }
 
 // 实现选择节点的 Get() 方法
 // Get获取散列中与所提供的键最近的项
 func (m *Map) Get(key string) string {
-	if len(m.keys) == 0 {
+	if len(m.ring) == 0 {
 		return ""
 	}
 
-	hash := int(m.hash([]byte(key))) //  第一步，计算 key 的哈希值
-	// 第二步，顺时针找到第一个匹配的虚拟节点的下标 idx, 从 m.keys 中获取到对应的哈希值
-	// 二分查找合适的虚拟节点
-	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
-	})
+	hash := m.hash([]byte(key)) //  第一步，计算 key 的哈希值
+	// 第二步，顺时针找到第一个匹配的虚拟节点的下标 idx
+	idx := m.search(hash)
 
-	return m.hashMap[m.keys[idx%len(m.keys)]] // 第三步，通过 hashMap 映射得到真实的节点
-	// 如果 idx == len(m.keys)，说明应选择 m.keys[0]，
-	// 因为 m.keys 是一个环状结构，所以用取余数的方式来处理这种情况。
+	if m.load != nil { // 有界负载模式下跳过负载过高的节点
+		if nodes := m.walk(idx, 1); len(nodes) > 0 {
+			return nodes[0]
+		}
+	}
+	return m.ring[idx%len(m.ring)].node // 第三步，得到虚拟节点对应的真实节点
+	// 如果 idx == len(m.ring)，说明应选择 m.ring[0]，
+	// 因为 m.ring 是一个环状结构，所以用取余数的方式来处理这种情况。
 } 
 
+// GetN 返回从 key 的位置开始顺时针遇到的前 n 个不同的真实节点，第一个就是 Get 返回的节点。
+// 真实节点不足 n 个时返回全部节点，用来把 key 复制到多个节点上。
+// 有界负载模式下负载过高的节点排在其他节点之后。
+func (m *Map) GetN(key string, n int) []string {
+	if len(m.ring) == 0 || n <= 0 {
+		return nil
+	}
+	return m.walk(m.search(m.hash([]byte(key))), n)
+}
+
+// walk 从下标 idx 开始顺时针绕环一周，返回前 n 个不同的真实节点。
+// 有界负载模式下负载过高的节点被推迟到最后。
+func (m *Map) walk(idx, n int) []string {
+	var capacity, loads map[string]int64
+	if m.load != nil {
+		capacity, loads = m.capacities()
+	}
+	nodes := make([]string, 0, n)
+	var overloaded []string
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.ring) && len(nodes) < n; i++ { // 最多绕环一周
+		node := m.ring[(idx+i)%len(m.ring)].node
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if capacity != nil && loads[node]+1 > capacity[node] {
+			overloaded = append(overloaded, node)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	for _, node := range overloaded {
+		if len(nodes) == n {
+			break
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
 // 删除只需要删除掉节点对应的虚拟节点和映射关系，
 // 至于均摊给其他节点，那是删除之后自然会发生的
-// Remove use to remove a key and its virtual keys on the ring and map
+// Remove use to remove a key and its virtual keys on the ring
+// 只删除属于该节点的虚拟节点，与它哈希冲突的其他节点的虚拟节点保持不变。
+// 不存在的节点会被忽略，可以重复调用。
 func (m *Map) Remove(key string) {
-	for i := 0; i < m.replicas; i++ {
-		hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-		idx := sort.SearchInts(m.keys, hash)
-		m.keys = append(m.keys[:idx], m.keys[idx+1:]...)
-		delete(m.hashMap, hash)
+	if _, ok := m.weights[key]; !ok {
+		return
+	}
+	delete(m.weights, key)
+	ring := m.ring[:0]
+	for _, v := range m.ring {
+		if v.node != key {
+			ring = append(ring, v)
+		}
+	}
+	m.ring = ring
+}
+
+// Range 是哈希环上的一段闭区间 [Start, End]，哈希值落在其中的 key 属于 Owner
+type Range struct {
+	Start, End uint64
+	Owner      string
+}
+
+// Ranges 把哈希环展开成覆盖 [0, 2^64-1] 的有序区间列表。
+// 最后一个虚拟节点之后的区间绕回到第一个虚拟节点，因此首尾两段属于同一个节点。
+// 环为空时返回 nil。
+func (m *Map) Ranges() []Range {
+	if len(m.ring) == 0 {
+		return nil
+	}
+	var ranges []Range
+	var start uint64
+	for i, v := range m.ring {
+		if i > 0 && v.hash == m.ring[i-1].hash { // 哈希冲突时位置属于排在前面的节点，与 Get 一致
+			continue
+		}
+		ranges = append(ranges, Range{Start: start, End: v.hash, Owner: v.node})
+		start = v.hash + 1
+	}
+	if last := m.ring[len(m.ring)-1].hash; last != math.MaxUint64 {
+		ranges = append(ranges, Range{Start: last + 1, End: math.MaxUint64, Owner: m.ring[0].node})
+	}
+	return ranges
+}
+
+// Move 表示哈希值在 [Start, End] 之间的 key 从 From 转移到了 To，
+// From 或 To 为空表示环在变化前或变化后为空
+type Move struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Moved 比较变化前后的 Ranges()，返回归属发生变化的区间，相邻且去向相同的区间会合并
+func Moved(before, after []Range) []Move {
+	whole := []Range{{Start: 0, End: math.MaxUint64}}
+	if len(before) == 0 {
+		before = whole
+	}
+	if len(after) == 0 {
+		after = whole
 	}
-}
\ No newline at end of file
+	var moves []Move
+	var start uint64
+	for i, j := 0, 0; ; {
+		a, b := before[i], after[j]
+		end := a.End
+		if b.End < end {
+			end = b.End
+		}
+		if a.Owner != b.Owner {
+			if n := len(moves); n > 0 && moves[n-1].End+1 == start && moves[n-1].From == a.Owner && moves[n-1].To == b.Owner {
+				moves[n-1].End = end
+			} else {
+				moves = append(moves, Move{Start: start, End: end, From: a.Owner, To: b.Owner})
+			}
+		}
+		if end == math.MaxUint64 {
+			return moves
+		}
+		if a.End == end {
+			i++
+		}
+		if b.End == end {
+			j++
+		}
+		start = end + 1
+	}
+}
@@ -1,8 +1,15 @@
 package consistenthash
 
 import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"strconv"
 	"testing"
+	"testing/quick"
 )
 
 func TestHashing(t *testing.T) {
@@ -37,4 +44,295 @@ func TestHashing(t *testing.T) {
 			t.Errorf("Asking for %s, should have yielded %s", k, v)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点: 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := map[string][]string{
+		"2":  {"2", "4", "6"},
+		"11": {"2", "4", "6"},
+		"23": {"4", "6", "2"},
+		"27": {"2", "4", "6"},
+	}
+	for k, v := range testCases {
+		if got := hash.GetN(k, 3); !reflect.DeepEqual(got, v) {
+			t.Errorf("GetN(%s, 3) = %v, want %v", k, got, v)
+		}
+		if got := hash.GetN(k, 1); got[0] != hash.Get(k) {
+			t.Errorf("GetN(%s, 1) = %v, want Get's %s", k, got, hash.Get(k))
+		}
+	}
+	if got := hash.GetN("23", 5); !reflect.DeepEqual(got, []string{"4", "6", "2"}) {
+		t.Errorf("GetN should return every node when n exceeds them, got %v", got)
+	}
+	if got := New(3, nil).GetN("23", 2); got != nil {
+		t.Errorf("empty ring should return nil, got %v", got)
+	}
+}
+
+func TestRangesAndMoved(t *testing.T) {
+	hash := New(1, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+
+	// 虚拟节点 "02" "04" 的哈希值为 2 和 4
+	hash.Add("2", "4")
+	before := hash.Ranges()
+	expect := []Range{
+		{Start: 0, End: 2, Owner: "2"},
+		{Start: 3, End: 4, Owner: "4"},
+		{Start: 5, End: math.MaxUint64, Owner: "2"},
+	}
+	if !reflect.DeepEqual(before, expect) {
+		t.Fatalf("unexpected ranges %v", before)
+	}
+
+	// 加入 8 之后，(4, 8] 从 2 转移到 8
+	hash.Add("8")
+	moves := Moved(before, hash.Ranges())
+	if !reflect.DeepEqual(moves, []Move{{Start: 5, End: 8, From: "2", To: "8"}}) {
+		t.Fatalf("unexpected moves after add %v", moves)
+	}
+
+	// 删除 2 之后，它负责的区间都转移到 4，首尾两段分开报告
+	before = hash.Ranges()
+	hash.Remove("2")
+	moves = Moved(before, hash.Ranges())
+	expectMoves := []Move{
+		{Start: 0, End: 2, From: "2", To: "4"},
+		{Start: 9, End: math.MaxUint64, From: "2", To: "4"},
+	}
+	if !reflect.DeepEqual(moves, expectMoves) {
+		t.Fatalf("unexpected moves after remove %v", moves)
+	}
+
+	// 从空环开始，所有 key 都从 "" 转移
+	if moves := Moved(nil, hash.Ranges()); len(moves) != 3 || moves[0].From != "" {
+		t.Fatalf("unexpected moves from empty ring %v", moves)
+	}
+}
+
+// 各节点分到的 key 与权重成正比，误差在一定范围内
+func TestWeightedDistribution(t *testing.T) {
+	hash := New(100, crc32.ChecksumIEEE) // 固定哈希函数，分布的误差不随默认哈希变化
+	weights := map[string]int{"small": 1, "medium": 2, "large": 4}
+	total := 0
+	for node, weight := range weights {
+		hash.AddWeighted(node, weight)
+		total += weight
+	}
+
+	const n = 100000
+	counts := make(map[string]int)
+	for i := 0; i < n; i++ {
+		counts[hash.Get("key"+strconv.Itoa(i))]++
+	}
+	const tolerance = 0.2 // 与期望份额的相对误差
+	for node, weight := range weights {
+		want := float64(weight) / float64(total)
+		got := float64(counts[node]) / n
+		if math.Abs(got-want) > tolerance*want {
+			t.Errorf("%s (weight %d) got %.3f of keys, want %.3f ± %.0f%%", node, weight, got, want, tolerance*100)
+		}
+	}
+
+	// 删除带权重的节点时删除它的全部虚拟节点
+	hash.Remove("large")
+	if len(hash.ring) != 300 {
+		t.Fatalf("expected 300 virtual nodes after removing large, got %d", len(hash.ring))
+	}
+	for i := 0; i < 1000; i++ {
+		if hash.Get("key"+strconv.Itoa(i)) == "large" {
+			t.Fatal("removed node still owns keys")
+		}
+	}
+}
+
+func TestBoundedLoads(t *testing.T) {
+	hash := New(1, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点 "02" "04" "06" 的哈希值为 2、4、6
+	hash.Add("2", "4", "6")
+	loads := map[string]int64{}
+	hash.SetBoundedLoads(0.25, func(node string) int64 { return loads[node] })
+
+	if got := hash.Get("3"); got != "4" {
+		t.Fatalf("without load 3 should map to 4, got %s", got)
+	}
+	// 总负载 10，上限 ceil(1.25 * 11 / 3) = 5，节点 4 超过上限
+	loads["4"] = 10
+	if got := hash.Get("3"); got != "6" {
+		t.Fatalf("overloaded 4 should be skipped, got %s", got)
+	}
+	if got := hash.GetN("3", 3); !reflect.DeepEqual(got, []string{"6", "2", "4"}) {
+		t.Fatalf("overloaded node should come last, got %v", got)
+	}
+	// 负载均衡后回到原来的节点
+	loads["2"], loads["4"], loads["6"] = 100, 100, 100
+	if got := hash.Get("3"); got != "4" {
+		t.Fatalf("balanced loads should map 3 to 4, got %s", got)
+	}
+
+	hash.SetBoundedLoads(0, nil)
+	if got := hash.Get("3"); got != "4" {
+		t.Fatalf("bounded loads disabled, expected 4, got %s", got)
+	}
+}
+
+// 虚拟节点哈希冲突时互不覆盖，删除一个节点不影响与它冲突的节点
+func TestCollisions(t *testing.T) {
+	hash := New(2, func(key []byte) uint32 { return 7 }) // 所有虚拟节点都在同一个位置
+	hash.Add("b", "a")
+	if len(hash.ring) != 4 {
+		t.Fatalf("colliding virtual nodes should all be kept, got %d", len(hash.ring))
+	}
+	if got := hash.Get("Tom"); got != "a" {
+		t.Fatalf("colliding position should belong to the smallest node, got %s", got)
+	}
+	if got := hash.GetN("Tom", 2); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("GetN should return both colliding nodes, got %v", got)
+	}
+
+	hash.Remove("a")
+	if got := hash.Get("Tom"); got != "b" {
+		t.Fatalf("b should own the position after removing a, got %s", got)
+	}
+	// 重复删除和删除不存在的节点都是空操作
+	hash.Remove("a")
+	hash.Remove("c")
+	if len(hash.ring) != 2 || hash.Get("Tom") != "b" {
+		t.Fatalf("removing missing nodes should not change the ring, got %v", hash.ring)
+	}
+	hash.Remove("b")
+	if got := hash.Get("Tom"); got != "" {
+		t.Fatalf("empty ring should return nothing, got %s", got)
+	}
+}
+
+// ringModel 是哈希环的参考实现：逐个比较所有虚拟节点，
+// 顺时针距离最近的虚拟节点胜出，距离相同时名称小的节点胜出
+type ringModel struct {
+	hash     Hash64
+	replicas int
+	weights  map[string]int
+}
+
+// vnodes 返回模型中所有的虚拟节点
+func (r *ringModel) vnodes() []vnode {
+	var vnodes []vnode
+	for node, weight := range r.weights {
+		for i := 0; i < r.replicas*weight; i++ {
+			vnodes = append(vnodes, vnode{hash: r.hash([]byte(strconv.Itoa(i) + node)), node: node})
+		}
+	}
+	return vnodes
+}
+
+// owners 按顺时针顺序返回哈希值 h 的所有候选节点
+func owners(vnodes []vnode, h uint64) []string {
+	sort.Slice(vnodes, func(i, j int) bool {
+		di, dj := vnodes[i].hash-h, vnodes[j].hash-h // uint64 相减自然绕环
+		return di < dj || di == dj && vnodes[i].node < vnodes[j].node
+	})
+	var owners []string
+	seen := make(map[string]bool)
+	for _, v := range vnodes {
+		if !seen[v.node] {
+			seen[v.node] = true
+			owners = append(owners, v.node)
+		}
+	}
+	return owners
+}
+
+// check 比较 m 与模型：虚拟节点数、Get、GetN 和 Ranges 的结果都应一致
+func (r *ringModel) check(m *Map) error {
+	total := 0
+	for _, weight := range r.weights {
+		total += r.replicas * weight
+	}
+	if len(m.ring) != total || !reflect.DeepEqual(m.weights, r.weights) {
+		return fmt.Errorf("ring has %d virtual nodes with weights %v, model has %d with %v", len(m.ring), m.weights, total, r.weights)
+	}
+	vnodes := r.vnodes()
+	for i := 0; i < 32; i++ {
+		key := strconv.Itoa(i)
+		owners := owners(vnodes, r.hash([]byte(key)))
+		want := ""
+		if len(owners) > 0 {
+			want = owners[0]
+		}
+		if got := m.Get(key); got != want {
+			return fmt.Errorf("Get(%s) = %q, model %q", key, got, want)
+		}
+		if got := m.GetN(key, len(r.weights)); !reflect.DeepEqual(got, owners) {
+			return fmt.Errorf("GetN(%s) = %v, model %v", key, got, owners)
+		}
+	}
+	for _, rg := range m.Ranges() {
+		for _, h := range []uint64{rg.Start, rg.End} {
+			if owners := owners(vnodes, h); owners[0] != rg.Owner {
+				return fmt.Errorf("range %v: hash %d belongs to %s in the model", rg, h, owners[0])
+			}
+		}
+	}
+	return nil
+}
+
+// 随机的 Add、AddWeighted 和 Remove 序列之后，哈希环的行为与参考模型一致。
+// 哈希空间只有 512，制造大量冲突
+func TestRingMatchesModel(t *testing.T) {
+	nodes := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	small := func(data []byte) uint64 { return defaultHash64(data) % 512 }
+	property := func(ops []uint16) bool {
+		m := New(4, nil, WithHash64(small))
+		model := &ringModel{hash: small, replicas: 4, weights: make(map[string]int)}
+		for _, op := range ops {
+			node, weight := nodes[op%8], int(op>>3%3)+1
+			switch op >> 5 % 3 {
+			case 0:
+				m.Add(node)
+				if model.weights[node] == 0 {
+					model.weights[node] = 1
+				}
+			case 1:
+				m.AddWeighted(node, weight)
+				model.weights[node] = weight
+			case 2:
+				m.Remove(node)
+				delete(model.weights, node)
+			}
+			if err := model.check(m); err != nil {
+				t.Logf("after %v: %v", ops, err)
+				return false
+			}
+		}
+		return true
+	}
+	config := &quick.Config{MaxCount: 100, Rand: rand.New(rand.NewSource(1))}
+	if err := quick.Check(property, config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 默认使用 64 位哈希，虚拟节点分布在整个 64 位空间
+func TestHash64(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b", "c")
+	if hash.ring[len(hash.ring)-1].hash <= math.MaxUint32 {
+		t.Fatal("default hash should use the whole 64-bit space")
+	}
+	ranges := hash.Ranges()
+	if ranges[0].Start != 0 || ranges[len(ranges)-1].End != math.MaxUint64 {
+		t.Fatalf("ranges should cover the whole ring, got %v ... %v", ranges[0], ranges[len(ranges)-1])
+	}
+}
@@ -0,0 +1,104 @@
+package consistenthash
+
+import "sort"
+
+// Jump 实现了 Lamping 和 Veach 的 jump consistent hash：不占额外内存，查找快，分布非常均匀。
+// 桶按节点名称排序分配，节点的添加顺序不影响 key 的归属，节点列表相同的两个节点总是选出同一个所有者。
+// 代价是它只能在末尾增删桶：增删的节点不在排序的末尾时，排在它后面的节点都会换桶，
+// 移动的 key 远多于其他算法。不支持权重。
+type Jump struct {
+	hash  Hash64
+	nodes []string       // 第 i 个桶对应的节点，按名称排序
+	index map[string]int // 节点对应的桶
+}
+
+// NewJump 创建 Jump 实例，fn 为 nil 时使用默认的 64 位哈希
+func NewJump(fn Hash64) *Jump {
+	if fn == nil {
+		fn = defaultHash64
+	}
+	return &Jump{hash: fn, index: make(map[string]int)}
+}
+
+// Add 添加节点并重新分配桶，已经存在的节点会被忽略
+func (j *Jump) Add(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if _, ok := j.index[node]; ok {
+			continue
+		}
+		j.index[node] = -1
+		j.nodes = append(j.nodes, node)
+		changed = true
+	}
+	if changed {
+		j.reindex()
+	}
+}
+
+// Remove 删除节点并重新分配桶，不存在的节点会被忽略
+func (j *Jump) Remove(node string) {
+	i, ok := j.index[node]
+	if !ok {
+		return
+	}
+	j.nodes = append(j.nodes[:i], j.nodes[i+1:]...)
+	delete(j.index, node)
+	j.reindex()
+}
+
+// reindex 按名称排序节点，重新记录每个节点对应的桶
+func (j *Jump) reindex() {
+	sort.Strings(j.nodes)
+	for i, node := range j.nodes {
+		j.index[node] = i
+	}
+}
+
+// jumpHash 把 key 映射到 [0, buckets) 中的一个桶，桶数从 n 增加到 n+1 时只有 1/(n+1) 的 key 移动
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Get 返回 key 所在的桶对应的节点
+func (j *Jump) Get(key string) string {
+	if len(j.nodes) == 0 {
+		return ""
+	}
+	return j.nodes[jumpHash(j.hash([]byte(key)), len(j.nodes))]
+}
+
+// GetN 依次对 key 的哈希值再哈希，选出不同的桶。
+// 尝试一定次数后仍然不足 n 个时，从最后选中的桶开始按顺序补齐。
+func (j *Jump) GetN(key string, n int) []string {
+	if len(j.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(j.nodes) {
+		n = len(j.nodes)
+	}
+	nodes := make([]string, 0, n)
+	seen := make(map[int]bool, n)
+	h := j.hash([]byte(key))
+	b := 0
+	for attempt := 0; len(nodes) < n && attempt < 4*n; attempt++ {
+		if b = jumpHash(h, len(j.nodes)); !seen[b] {
+			seen[b] = true
+			nodes = append(nodes, j.nodes[b])
+		}
+		h = mix64(h + 1)
+	}
+	for i := 1; len(nodes) < n; i++ {
+		if next := (b + i) % len(j.nodes); !seen[next] {
+			seen[next] = true
+			nodes = append(nodes, j.nodes[next])
+		}
+	}
+	return nodes
+}
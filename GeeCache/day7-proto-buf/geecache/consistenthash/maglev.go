@@ -0,0 +1,149 @@
+package consistenthash
+
+import "sort"
+
+// DefaultMaglevSize 是 Maglev 查找表的默认大小，必须是质数，并且远大于节点数
+const DefaultMaglevSize = 65537
+
+// Maglev 实现了 Google Maglev 负载均衡器的一致性哈希：每个节点按自己的排列轮流占据查找表中的位置，
+// 查找只需要一次取模，各节点分到的位置数几乎完全相同。节点变化时重建查找表，
+// 除了必须移动的 key，还有少量 key 会在没有变化的节点之间移动。
+// 权重通过每轮多占几个位置实现。
+type Maglev struct {
+	hash    Hash64
+	size    int      // 查找表大小
+	nodes   []string // 按名称排序，查找表与节点的添加顺序无关
+	weights map[string]int
+	table   []int // 查找表，值是 nodes 中的下标
+}
+
+// NewMaglev 创建 Maglev 实例，size 为查找表大小，<= 0 时使用 DefaultMaglevSize，
+// 不是质数时取下一个质数。fn 为 nil 时使用默认的 64 位哈希。
+func NewMaglev(size int, fn Hash64) *Maglev {
+	if size <= 0 {
+		size = DefaultMaglevSize
+	}
+	for !isPrime(size) {
+		size++
+	}
+	if fn == nil {
+		fn = defaultHash64
+	}
+	return &Maglev{hash: fn, size: size, weights: make(map[string]int)}
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add 添加权重为 1 的节点，已经存在的节点会被忽略
+func (m *Maglev) Add(nodes ...string) {
+	changed := false
+	for _, node := range nodes {
+		if _, ok := m.weights[node]; !ok {
+			m.weights[node] = 1
+			changed = true
+		}
+	}
+	if changed {
+		m.populate()
+	}
+}
+
+// AddWeighted 添加权重为 weight 的节点，已经存在的节点更新权重，weight <= 0 时不添加
+func (m *Maglev) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	m.weights[node] = weight
+	m.populate()
+}
+
+// Remove 删除节点并重建查找表，不存在的节点会被忽略
+func (m *Maglev) Remove(node string) {
+	if _, ok := m.weights[node]; !ok {
+		return
+	}
+	delete(m.weights, node)
+	m.populate()
+}
+
+// populate 重建查找表：节点 i 的排列为 (offset_i + j*skip_i) mod size，
+// 每一轮各节点按权重依次占据自己排列中下一个空位，直到填满
+func (m *Maglev) populate() {
+	m.nodes = m.nodes[:0]
+	for node := range m.weights {
+		m.nodes = append(m.nodes, node)
+	}
+	sort.Strings(m.nodes)
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+
+	size := uint64(m.size)
+	offsets := make([]uint64, len(m.nodes))
+	skips := make([]uint64, len(m.nodes))
+	for i, node := range m.nodes {
+		h := m.hash([]byte(node))
+		offsets[i] = h % size
+		skips[i] = mix64(h)%(size-1) + 1 // size 是质数，skip 与它互质，排列覆盖所有位置
+	}
+
+	table := make([]int, m.size)
+	for i := range table {
+		table[i] = -1
+	}
+	next := make([]uint64, len(m.nodes))
+	for filled := 0; filled < m.size; {
+		for i, node := range m.nodes {
+			for w := 0; w < m.weights[node] && filled < m.size; w++ {
+				c := (offsets[i] + next[i]*skips[i]) % size
+				for table[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % size
+				}
+				table[c] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+	m.table = table
+}
+
+// Get 返回查找表中 key 对应位置的节点
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.nodes[m.table[m.hash([]byte(key))%uint64(m.size)]]
+}
+
+// GetN 从 key 对应的位置开始依次向后查找，返回前 n 个不同的节点
+func (m *Maglev) GetN(key string, n int) []string {
+	if len(m.table) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(m.nodes) {
+		n = len(m.nodes)
+	}
+	nodes := make([]string, 0, n)
+	seen := make(map[int]bool, n)
+	start := int(m.hash([]byte(key)) % uint64(m.size))
+	for i := 0; i < m.size && len(nodes) < n; i++ {
+		if idx := m.table[(start+i)%m.size]; !seen[idx] {
+			seen[idx] = true
+			nodes = append(nodes, m.nodes[idx])
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,51 @@
+package consistenthash
+
+import (
+	"hash/fnv"
+)
+
+// Placement 决定每个 key 由哪些节点负责。
+// Map（哈希环）、Rendezvous、Jump 和 Maglev 都实现了它，和 Map 一样不是并发安全的，由调用方加锁。
+type Placement interface {
+	// Add 添加节点，已经存在的节点会被忽略
+	Add(nodes ...string)
+	// Remove 删除节点
+	Remove(node string)
+	// Get 返回 key 所属的节点，没有节点时返回空字符串
+	Get(key string) string
+	// GetN 返回 key 的前 n 个不同的候选节点，第一个与 Get 相同
+	GetN(key string, n int) []string
+}
+
+// WeightedPlacement 是支持节点权重的 Placement，节点分到的 key 大致与权重成正比
+type WeightedPlacement interface {
+	Placement
+	AddWeighted(node string, weight int)
+}
+
+var (
+	_ WeightedPlacement = (*Map)(nil)
+	_ WeightedPlacement = (*Rendezvous)(nil)
+	_ Placement         = (*Jump)(nil)
+	_ WeightedPlacement = (*Maglev)(nil)
+)
+
+// Hash64 映射到 uint64，所有算法都使用 64 位哈希，默认为 FNV-1a 加上 mix64
+type Hash64 func(data []byte) uint64
+
+// defaultHash64 是 FNV-1a，再用 mix64 打散，FNV 的低位分布不够均匀
+func defaultHash64(data []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(data)
+	return mix64(h.Sum64())
+}
+
+// mix64 是 splitmix64 的终结函数，把输入的每一位扩散到输出的所有位上
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,219 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// placements 是参与比较的各个算法，anyMove 表示在排序中间增删节点时会移动大量 key
+var placements = []struct {
+	name    string
+	new     func() Placement
+	anyMove bool
+}{
+	{"ring", func() Placement { return New(50, nil) }, false},
+	{"rendezvous", func() Placement { return NewRendezvous(nil) }, false},
+	{"jump", func() Placement { return NewJump(nil) }, true},
+	{"maglev", func() Placement { return NewMaglev(0, nil) }, false},
+}
+
+func nodeNames(n int) []string {
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = "http://10.0.0." + strconv.Itoa(i) + ":8001"
+	}
+	return nodes
+}
+
+// 所有实现都满足 Placement 的约定
+func TestPlacementContract(t *testing.T) {
+	for _, p := range placements {
+		t.Run(p.name, func(t *testing.T) {
+			pl := p.new()
+			if pl.Get("Tom") != "" || pl.GetN("Tom", 2) != nil {
+				t.Fatal("empty placement should return nothing")
+			}
+			nodes := nodeNames(10)
+			pl.Add(nodes...)
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i)
+				got := pl.GetN(key, 3)
+				if len(got) != 3 || got[0] != pl.Get(key) {
+					t.Fatalf("GetN(%s, 3) = %v, Get = %s", key, got, pl.Get(key))
+				}
+				if got[0] == got[1] || got[1] == got[2] || got[0] == got[2] {
+					t.Fatalf("GetN(%s, 3) returned duplicates %v", key, got)
+				}
+				if all := pl.GetN(key, 20); len(all) != len(nodes) {
+					t.Fatalf("GetN should return every node when n exceeds them, got %d", len(all))
+				}
+			}
+
+			pl.Remove(nodes[3])
+			for i := 0; i < 1000; i++ {
+				if pl.Get(strconv.Itoa(i)) == nodes[3] {
+					t.Fatal("removed node still owns keys")
+				}
+			}
+		})
+	}
+}
+
+// 节点的添加顺序不影响 key 的归属，否则节点列表相同的两个节点会选出不同的所有者
+func TestPlacementOrderIndependent(t *testing.T) {
+	for _, p := range placements {
+		t.Run(p.name, func(t *testing.T) {
+			nodes := nodeNames(10)
+			a, b := p.new(), p.new()
+			a.Add(nodes...)
+			for i := len(nodes) - 1; i >= 0; i-- {
+				b.Add(nodes[i])
+			}
+			// 删除后再加回来，两者的节点列表仍然相同
+			a.Remove(nodes[2])
+			a.Add(nodes[2])
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i)
+				if a.Get(key) != b.Get(key) {
+					t.Fatalf("%s owned by %s and %s depending on add order", key, a.Get(key), b.Get(key))
+				}
+			}
+		})
+	}
+}
+
+// placementReport 记录一个算法的均衡程度和节点变化时移动的 key 比例
+type placementReport struct {
+	maxShare    float64 // 分到最多 key 的节点占平均值的倍数
+	stddev      float64 // 各节点 key 数的相对标准差
+	movedAdd    float64 // 增加一个节点后移动的 key 比例
+	movedRemove float64 // 删除一个节点后移动的 key 比例
+	extra       float64 // 删除节点时，在未变化的节点之间移动的 key 比例
+}
+
+// measurePlacement 把 keys 个 key 分配到 nodes 个节点上，然后增加一个节点、删除中间的一个节点，统计 key 的移动
+func measurePlacement(newPlacement func() Placement, nodes, keys int) placementReport {
+	names := nodeNames(nodes + 1)
+	pl := newPlacement()
+	pl.Add(names[:nodes]...)
+	owners := make([]string, keys)
+	counts := make(map[string]int)
+	for i := range owners {
+		owners[i] = pl.Get("key" + strconv.Itoa(i))
+		counts[owners[i]]++
+	}
+
+	var r placementReport
+	avg := float64(keys) / float64(nodes)
+	var sum float64
+	for _, c := range counts {
+		r.maxShare = math.Max(r.maxShare, float64(c)/avg)
+		sum += (float64(c) - avg) * (float64(c) - avg)
+	}
+	r.stddev = math.Sqrt(sum/float64(nodes)) / avg
+
+	pl.Add(names[nodes])
+	moved := 0
+	for i := range owners {
+		if pl.Get("key"+strconv.Itoa(i)) != owners[i] {
+			moved++
+		}
+	}
+	r.movedAdd = float64(moved) / float64(keys)
+	pl.Remove(names[nodes])
+
+	removed := names[nodes/2]
+	pl.Remove(removed)
+	moved, extra := 0, 0
+	for i := range owners {
+		if owner := pl.Get("key" + strconv.Itoa(i)); owner != owners[i] {
+			moved++
+			if owners[i] != removed {
+				extra++
+			}
+		}
+	}
+	r.movedRemove = float64(moved) / float64(keys)
+	r.extra = float64(extra) / float64(keys)
+	return r
+}
+
+// TestPlacementComparison 比较各个算法的均衡程度和节点变化时的 key 移动，
+// go test -v -run PlacementComparison 输出对比表格
+func TestPlacementComparison(t *testing.T) {
+	const nodes, keys = 10, 100000
+	t.Logf("%-12s %9s %8s %10s %13s %7s", "placement", "max/avg", "stddev", "moved+1", "moved-1", "extra")
+	for _, p := range placements {
+		r := measurePlacement(p.new, nodes, keys)
+		t.Logf("%-12s %9.3f %8.3f %10.4f %13.4f %7.4f", p.name, r.maxShare, r.stddev, r.movedAdd, r.movedRemove, r.extra)
+
+		// 理想情况下增加节点移动 1/(n+1)，删除节点移动 1/n。
+		// Jump 按名称排序分配桶，在排序中间增删节点会移动大量 key，只比较均衡程度
+		if !p.anyMove {
+			if ideal := 1.0 / (nodes + 1); r.movedAdd > 2*ideal {
+				t.Errorf("%s moved %.4f of keys when adding a node, ideal %.4f", p.name, r.movedAdd, ideal)
+			}
+			if ideal := 1.0 / nodes; r.movedRemove > 2.5*ideal {
+				t.Errorf("%s moved %.4f of keys when removing a node, ideal %.4f", p.name, r.movedRemove, ideal)
+			}
+		}
+		if r.maxShare > 1.5 {
+			t.Errorf("%s max share %.3f of the average", p.name, r.maxShare)
+		}
+	}
+}
+
+// 带权重的实现按权重分配 key
+func TestWeightedPlacements(t *testing.T) {
+	for _, p := range placements {
+		pl, ok := p.new().(WeightedPlacement)
+		if !ok {
+			continue
+		}
+		t.Run(p.name, func(t *testing.T) {
+			weights := map[string]int{"small": 1, "medium": 2, "large": 4}
+			names := make([]string, 0, len(weights))
+			for node := range weights {
+				names = append(names, node)
+			}
+			sort.Strings(names)
+			for _, node := range names {
+				pl.AddWeighted(node, weights[node])
+			}
+			pl.Add(names...) // 再次 Add 已有的节点不改变权重
+			const n = 70000
+			counts := make(map[string]int)
+			for i := 0; i < n; i++ {
+				counts[pl.Get("key"+strconv.Itoa(i))]++
+			}
+			for node, weight := range weights {
+				want := float64(weight) / 7
+				if got := float64(counts[node]) / n; math.Abs(got-want) > 0.2*want {
+					t.Errorf("%s (weight %d) got %.3f of keys, want %.3f", node, weight, got, want)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPlacementGet(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	for _, p := range placements {
+		for _, nodes := range []int{10, 100} {
+			b.Run(fmt.Sprintf("%s/%d", p.name, nodes), func(b *testing.B) {
+				pl := p.new()
+				pl.Add(nodeNames(nodes)...)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					pl.Get(keys[i&(len(keys)-1)])
+				}
+			})
+		}
+	}
+}
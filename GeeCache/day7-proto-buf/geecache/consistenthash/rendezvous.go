@@ -0,0 +1,105 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous 实现了最高随机权重哈希(HRW)：对每个节点计算 (节点, key) 的得分，key 属于得分最高的节点。
+// 不需要虚拟节点，分布均匀，增删节点时只移动必须移动的 key；代价是每次查找都要遍历所有节点。
+// 得分是 mix64(hash(key) ^ hash(节点))，节点的哈希值预先算好，每次查找只哈希一次 key。
+// 权重使用对数方法：得分为 -weight / ln(u)，u 是把上述哈希值映射到 (0, 1) 的结果。
+type Rendezvous struct {
+	hash    Hash64
+	nodes   []string
+	hashes  []uint64 // 与 nodes 一一对应的节点哈希值
+	weights map[string]int
+}
+
+// NewRendezvous 创建 Rendezvous 实例，fn 为 nil 时使用默认的 64 位哈希
+func NewRendezvous(fn Hash64) *Rendezvous {
+	if fn == nil {
+		fn = defaultHash64
+	}
+	return &Rendezvous{hash: fn, weights: make(map[string]int)}
+}
+
+// Add 添加权重为 1 的节点，已经存在的节点会被忽略，不会改变它的权重
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		if _, ok := r.weights[node]; !ok {
+			r.AddWeighted(node, 1)
+		}
+	}
+}
+
+// AddWeighted 添加权重为 weight 的节点，已经存在的节点更新权重，weight <= 0 时不添加
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	if weight <= 0 {
+		return
+	}
+	if _, ok := r.weights[node]; !ok {
+		r.nodes = append(r.nodes, node)
+		r.hashes = append(r.hashes, r.hash([]byte(node)))
+	}
+	r.weights[node] = weight
+}
+
+// Remove 删除节点，不存在的节点会被忽略
+func (r *Rendezvous) Remove(node string) {
+	if _, ok := r.weights[node]; !ok {
+		return
+	}
+	delete(r.weights, node)
+	for i, n := range r.nodes {
+		if n == node {
+			r.nodes = append(r.nodes[:i], r.nodes[i+1:]...)
+			r.hashes = append(r.hashes[:i], r.hashes[i+1:]...)
+			break
+		}
+	}
+}
+
+// score 计算第 i 个节点对哈希值为 keyHash 的 key 的得分
+func (r *Rendezvous) score(i int, keyHash uint64) float64 {
+	h := mix64(keyHash ^ r.hashes[i])
+	u := (float64(h>>11) + 0.5) / (1 << 53) // 取高 53 位映射到 (0, 1)
+	return -float64(r.weights[r.nodes[i]]) / math.Log(u)
+}
+
+// Get 返回得分最高的节点
+func (r *Rendezvous) Get(key string) string {
+	keyHash := r.hash([]byte(key))
+	best, bestScore := "", math.Inf(-1)
+	for i, node := range r.nodes {
+		if s := r.score(i, keyHash); s > bestScore {
+			best, bestScore = node, s
+		}
+	}
+	return best
+}
+
+// GetN 按得分从高到低返回前 n 个节点
+func (r *Rendezvous) GetN(key string, n int) []string {
+	if len(r.nodes) == 0 || n <= 0 {
+		return nil
+	}
+	type scored struct {
+		node  string
+		score float64
+	}
+	keyHash := r.hash([]byte(key))
+	all := make([]scored, len(r.nodes))
+	for i, node := range r.nodes {
+		all[i] = scored{node, r.score(i, keyHash)}
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+	if n > len(all) {
+		n = len(all)
+	}
+	nodes := make([]string, n)
+	for i := range nodes {
+		nodes[i] = all[i].node
+	}
+	return nodes
+}
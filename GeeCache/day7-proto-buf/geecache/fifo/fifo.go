@@ -0,0 +1,153 @@
+package fifo
+
+import (
+	"container/list"
+	"geecache/lru"
+	"time"
+)
+
+// Value 与 lru.Value 相同，使用 Len 来计算它需要多少字节
+type Value = lru.Value
+
+// EvictReason 与 lru.EvictReason 相同，表示记录被移除的原因
+type EvictReason = lru.EvictReason
+
+// Cache is a FIFO cache. It is not safe for concurrent access.
+// 先进先出：淘汰最早添加的记录，访问不会改变记录在队列中的位置。
+type Cache struct {
+	maxBytes int64                    // 允许使用的最大内存
+	nbytes   int64                    // 当前已使用的内存
+	ll       *list.List               // 双向链表实现的队列，front 为最新添加的记录
+	cache    map[string]*list.Element // 键是字符串，值是双向链表中对应节点的指针
+	// 可选并在清除entry时执行。
+	OnEvicted func(key string, value Value)
+	// 可选，与 OnEvicted 相同，但额外带上移除原因
+	OnEvictedWithReason func(key string, value Value, reason EvictReason)
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// New 是 Cache 的构造函数
+func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		ll:        list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Get 查找键的值，不改变记录在队列中的位置
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(ele, lru.EvictExpired)
+			return nil, false
+		}
+		return kv.value, true
+	}
+	return
+}
+
+// RemoveOldest 淘汰最早添加的记录（队首）
+func (c *Cache) RemoveOldest() {
+	if ele := c.ll.Back(); ele != nil {
+		c.removeElement(ele, lru.EvictCapacity)
+	}
+}
+
+// Remove 从缓存中删除 key 对应的记录
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, lru.EvictRemoved)
+	}
+}
+
+// RemoveExpired 移除所有已过期的记录，返回移除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev()
+		if ele.Value.(*entry).expired(now) {
+			c.removeElement(ele, lru.EvictExpired)
+			n++
+		}
+		ele = prev
+	}
+	return n
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason EvictReason) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry)
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+	if c.OnEvictedWithReason != nil {
+		c.OnEvictedWithReason(kv.key, kv.value, reason)
+	}
+}
+
+// Add 向缓存中添加一个值，该值永不过期
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL 向缓存中添加一个值，ttl 之后该值过期；ttl <= 0 表示永不过期。
+// 更新已存在的键不会改变它在队列中的位置。
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		kv.expire = expire
+	} else {
+		ele := c.ll.PushFront(&entry{key, value, expire})
+		c.cache[key] = ele
+		c.nbytes += int64(len(key)) + int64(value.Len())
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
+
+// Len 用来获取添加了多少条数据
+func (c *Cache) Len() int {
+	return c.ll.Len()
+}
+
+// Bytes 返回当前已使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
+
+// Range 从最新添加的记录开始遍历未过期的记录，f 返回 false 时停止。
+// 遍历过程中不能修改缓存。
+func (c *Cache) Range(f func(key string, value Value) bool) {
+	now := time.Now()
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*entry)
+		if kv.expired(now) {
+			continue
+		}
+		if !f(kv.key, kv.value) {
+			return
+		}
+	}
+}
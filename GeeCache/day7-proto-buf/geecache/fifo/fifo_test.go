@@ -0,0 +1,65 @@
+package fifo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// 测试淘汰顺序只与添加顺序有关，与访问无关
+func TestRemoveOldest(t *testing.T) {
+	keys := make([]string, 0)
+	fifo := New(int64(len("k1v1k2v2k3v3")), func(key string, value Value) {
+		keys = append(keys, key)
+	})
+	fifo.Add("k1", String("v1"))
+	fifo.Add("k2", String("v2"))
+	fifo.Add("k3", String("v3"))
+	fifo.Get("k1") // 访问 k1 不会让它免于淘汰
+	fifo.Add("k4", String("v4"))
+	fifo.Add("k1", String("v1"))
+
+	expect := []string{"k1", "k2"}
+	if !reflect.DeepEqual(expect, keys) || fifo.Len() != 3 {
+		t.Fatalf("expected evicted keys %v, got %v", expect, keys)
+	}
+}
+
+func TestAddWithTTL(t *testing.T) {
+	fifo := New(int64(0), nil)
+	fifo.AddWithTTL("k1", String("v1"), 10*time.Millisecond)
+	fifo.AddWithTTL("k2", String("v2"), 10*time.Millisecond)
+	fifo.Add("k3", String("v3"))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := fifo.Get("k1"); ok {
+		t.Fatalf("k1 should be expired")
+	}
+	if n := fifo.RemoveExpired(); n != 1 || fifo.Len() != 1 || fifo.Bytes() != int64(len("k3v3")) {
+		t.Fatalf("expected only k3 left, removed %d", n)
+	}
+}
+
+func TestRange(t *testing.T) {
+	fifo := New(int64(0), nil)
+	fifo.Add("k1", String("v1"))
+	fifo.AddWithTTL("k2", String("v2"), time.Nanosecond)
+	fifo.Add("k3", String("v3"))
+	fifo.Get("k1") // 访问不改变顺序
+	time.Sleep(time.Millisecond)
+
+	var keys []string
+	fifo.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !reflect.DeepEqual(keys, []string{"k3", "k1"}) {
+		t.Fatalf("expected newest first without expired keys, got %v", keys)
+	}
+}
@@ -1,10 +1,14 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"geecache/singleflight"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 	pb "geecache/geecachepb"
 )
 
@@ -12,13 +16,29 @@ import (
 type Group struct {
 	name string // 每个 Group 拥有一个唯一的名称 name
 	getter Getter // 缓存未命中时获取源数据的回调(callback)
-	mainCache cache // 一开始实现的并发缓存
+	mainCache cache // 一开始实现的并发缓存, 存放本节点负责的 key
+	// hotCache 存放从其他节点获取的热点 key 的副本，避免热点 key 每次都访问远程节点
+	hotCache cache
+	hotSampleRate int // 从远程节点获取的值，每 hotSampleRate 个中约有 1 个放入 hotCache
+	// negCache 记录最近确认不存在的 key，防止大量请求不存在的 key 时每次都穿透到数据源
+	negCache cache
+	negTTL time.Duration // 不存在的结果缓存多久，0 表示关闭负缓存
+	bloom *bloomGuard // 布隆过滤器，拦截一定不存在的 key，可以为 nil
+	refresh *refreshAhead // 提前刷新模式的配置，可以为 nil
+	refreshing sync.Map // 正在后台刷新的 key
 	peers PeerPicker
 	// use singleflight.Group to make sure that
 	// each key is only fetched once
 	loader *singleflight.Group // 添加成员变量 loader
+	peerLoader *singleflight.Group // 其他节点转发来的请求单独合并，见 loadForPeer
+	stats groupStats // 统计计数，通过 Stats() 读取
+	loadLatency *histogram // 加载耗时，由 /metrics 输出
 }
 
+// ErrNotFound 表示 key 在数据源中不存在。Getter 返回它（或用 %w 包装它的错误）时，
+// Group 会在开启负缓存后把这个结果缓存一段时间，HTTP 接口返回 404。
+var ErrNotFound = errors.New("geecache: key not found")
+
 // Getter为一个键加载数据
 type Getter interface {
 	Get(key string) ([]byte, error)
@@ -32,23 +52,137 @@ func (f GetterFunc) Get(key string) ([]byte, error) {
 	return f(key)
 }
 
+// TTLGetter 在返回数据的同时返回它的有效期，ttl <= 0 表示永不过期。
+// Getter 如果同时实现了 TTLGetter，Group 会优先调用 GetWithTTL，
+// 缓存值过期后 Group.Get 会重新从数据源加载。
+type TTLGetter interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// TTLGetterFunc 通过一个函数实现TTLGetter，同时也实现了Getter，可以直接传给NewGroup。
+type TTLGetterFunc func(key string) ([]byte, time.Duration, error)
+
+// GetWithTTL实现了TTLGetter接口功能
+func (f TTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// Get实现了Getter接口功能，忽略有效期
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(key)
+	return bytes, err
+}
+
+// ContextGetter 与 Getter 相同，但接收调用者的 context，数据源可以据此放弃耗时的查询。
+// Getter 如果同时实现了 ContextGetter，Group 会优先调用 GetContext。
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// ContextGetterFunc 通过一个函数实现ContextGetter，同时也实现了Getter，可以直接传给NewGroup。
+type ContextGetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+// GetContext实现了ContextGetter接口功能
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// Get实现了Getter接口功能，使用 context.Background()
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// ContextTTLGetter 是同时接收 context、返回有效期的 Getter，优先级最高
+type ContextTTLGetter interface {
+	GetWithTTLContext(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// ContextTTLGetterFunc 通过一个函数实现ContextTTLGetter，同时也实现了Getter，可以直接传给NewGroup。
+type ContextTTLGetterFunc func(ctx context.Context, key string) ([]byte, time.Duration, error)
+
+// GetWithTTLContext实现了ContextTTLGetter接口功能
+func (f ContextTTLGetterFunc) GetWithTTLContext(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
+// Get实现了Getter接口功能，使用 context.Background() 并忽略有效期
+func (f ContextTTLGetterFunc) Get(key string) ([]byte, error) {
+	bytes, _, err := f(context.Background(), key)
+	return bytes, err
+}
+
 var (
 	mu sync.RWMutex
 	groups = make(map[string]*Group)
 )
 
+// 热点缓存默认的采样率：从远程节点获取的值中约 1/10 放入 hotCache
+const defaultHotSampleRate = 10
+
+// GroupOption 用来配置 Group 的可选参数，传给 NewGroup
+type GroupOption func(*Group)
+
+// WithShards 把 mainCache 和 hotCache 各分成 n 个分片，每个分片有独立的锁，
+// 内存上限平均分给各个分片，默认为 1 个分片。读多的场景下可以设为 GOMAXPROCS 的数倍。
+// 注意每个分片的内存上限变小了，大于上限的值无法被缓存。
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.shardCount = n
+		g.hotCache.shardCount = n
+		g.negCache.shardCount = n
+	}
+}
+
+// WithNegativeCache 开启负缓存：数据源返回 ErrNotFound 的 key 在 ttl 内直接返回 ErrNotFound，
+// 不再访问数据源或远程节点，maxBytes 为负缓存的内存上限。Set 和 Invalidate 会清除对应的记录。
+func WithNegativeCache(ttl time.Duration, maxBytes int64) GroupOption {
+	return func(g *Group) {
+		g.negTTL = ttl
+		g.negCache.cacheBytes = maxBytes
+	}
+}
+
+// WithHotCacheBytes 设置 hotCache 的内存上限，默认是 cacheBytes 的 1/8，0 表示不限制
+func WithHotCacheBytes(hotCacheBytes int64) GroupOption {
+	return func(g *Group) {
+		g.hotCache.cacheBytes = hotCacheBytes
+	}
+}
+
+// WithHotCacheSampleRate 设置 hotCache 的采样率：从远程节点获取的值中
+// 约 1/rate 会放入 hotCache。rate 为 1 时全部放入，rate <= 0 时关闭 hotCache。
+func WithHotCacheSampleRate(rate int) GroupOption {
+	return func(g *Group) {
+		g.hotSampleRate = rate
+	}
+}
+
 // NewGroup 创建 Group的一个实例, 实例化 Group，并且将 group 存储在全局变量 groups 中
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("nil Getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
+	hotBytes := cacheBytes / 8
+	if cacheBytes > 0 && hotBytes == 0 { // cacheBytes < 8 时至少 1 字节，0 会变成不限制
+		hotBytes = 1
+	}
 	g := &Group{
 		name : name,
 		getter : getter,
 		mainCache : cache{cacheBytes: cacheBytes},
+		hotCache: cache{cacheBytes: hotBytes},
+		hotSampleRate: defaultHotSampleRate,
 		loader: &singleflight.Group{},
+		peerLoader: &singleflight.Group{},
+		loadLatency: newHistogram(defaultLatencyBuckets),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.bloom != nil && g.bloom.interval > 0 {
+		go g.rebuildLoop(g.bloom.interval)
 	}
 	groups[name] = g
 	return g
@@ -62,21 +196,78 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// allGroups 返回所有用 NewGroup 创建的 Group 的副本
+func allGroups() map[string]*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+	all := make(map[string]*Group, len(groups))
+	for name, g := range groups {
+		all[name] = g
+	}
+	return all
+}
+
 // Get 方法 从缓存中获取一个键的值
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 与 Get 相同，ctx 被取消或超时后立即返回 ctx.Err()。
+// 同一个 key 的加载由所有调用者共享，只有所有调用者都放弃时才会取消加载，
+// 加载使用的 context 会传给 ContextGetter 和远程节点。
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
+	return g.get(ctx, key, g.load)
+}
+
+// getForPeer 处理其他节点转发来的请求：查找本地缓存，未命中时只从本地数据源加载，不再转发。
+// 发送方已经认为本节点是 key 的所有者，两边的哈希环不一致时再转发可能形成环路。
+func (g *Group) getForPeer(ctx context.Context, key string) (ByteView, error) {
+	return g.get(ctx, key, g.loadForPeer)
+}
+
+// get 查找缓存，未命中时调用 load 加载
+func (g *Group) get(ctx context.Context, key string, load func(ctx context.Context, key string) (ByteView, error)) (ByteView, error) {
+	g.stats.gets.Add(1)
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
 
-	// 流程 ⑴ ：从 mainCache 中查找缓存，如果存在则返回缓存值。
+	if v, ok, err := g.lookupCache(key); ok {
+		return v, err
+	}
 
+	// 流程 ⑶ ：缓存不存在，则调用 load 方法
+	return load(ctx, key)
+}
+
+// lookupCache 依次查找 mainCache、hotCache、负缓存和布隆过滤器，ok 为 true 时不需要再加载
+func (g *Group) lookupCache(key string) (value ByteView, ok bool, err error) {
+	// 流程 ⑴ ：从 mainCache 中查找缓存，如果存在则返回缓存值。
 	if v, ok := g.mainCache.get(key); ok {
+		g.stats.cacheHits.Add(1)
 		log.Println("[GeeCache] hit")
-		return v, nil
+		g.maybeRefresh(key, v) // 提前刷新模式下，快要过期或已经软过期的值在后台重新加载
+		return v, true, nil
 	}
-
-	// 流程 ⑶ ：缓存不存在，则调用 load 方法
-	return g.load(key)
+	// 再从 hotCache 中查找其他节点负责的热点 key
+	if v, ok := g.hotCache.get(key); ok {
+		g.stats.cacheHits.Add(1)
+		log.Println("[GeeCache] hot cache hit")
+		return v, true, nil
+	}
+	// 最近确认过不存在的 key 直接返回
+	if g.negTTL > 0 {
+		if _, ok := g.negCache.get(key); ok {
+			g.stats.negativeHits.Add(1)
+			return ByteView{}, true, ErrNotFound
+		}
+	}
+	// 布隆过滤器认为一定不存在的 key 不再加载
+	if !g.bloom.mayContain(key) {
+		g.stats.bloomRejects.Add(1)
+		return ByteView{}, true, ErrNotFound
+	}
+	return ByteView{}, false, nil
 }
 
 // RegisterPeers registers a PeerPicker for choosing remote peer
@@ -89,20 +280,34 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 }
 
 // load 调用 getLocally（分布式场景下会调用 getFromPeer 从其他节点获取）
-func (g *Group) load(key string) (value ByteView, err error) {
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
 	// each key is only fetched once (either locally or remotely)
 	// regardless of the number of concurrent callers.
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
+	g.stats.loads.Add(1)
+	viewi, err, _ := g.loader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		g.stats.loadsDeduped.Add(1)
+		defer func(start time.Time) { g.loadLatency.observe(time.Since(start)) }(time.Now())
 		if g.peers != nil {
 			if peer, ok := g.peers.PickPeer(key); ok { // 修改 load 方法，使用 PickPeer() 方法选择节点
-				if value, err = g.getFromPeer(peer, key); err == nil { // 若非本机节点，则调用 getFromPeer() 从远程获取
+				if value, err = g.getFromPeer(ctx, peer, key); err == nil { // 若非本机节点，则调用 getFromPeer() 从远程获取
+					g.populatePeerValue(key, value)
 					return value, nil
 				}
+				if errors.Is(err, ErrNotFound) { // 所属节点确认 key 不存在，不需要再回退到本地加载
+					g.populateNegative(key)
+					return nil, err
+				}
+				g.stats.peerErrors.Add(1)
 				log.Println("[GeeCache] Failed to get from peer", err)
 			}
 		}
 	
-		return g.getLocally(key) // 若是本机节点或失败，则回退到 getLocally()。
+		value, err := g.getLocally(ctx, key) // 若是本机节点或失败，则回退到 getLocally()。
+		g.countLocal(key, err)
+		if err != nil {
+			return nil, err
+		}
+		return value, nil
 	})
 
 	if err == nil {
@@ -111,34 +316,251 @@ func (g *Group) load(key string) (value ByteView, err error) {
 	return
 }
 
+// loadForPeer 只从本地数据源加载，用于其他节点转发来的请求。它使用单独的 singleflight，
+// 不会合并到本节点正在转发给其他节点的同一个 key 上，否则两个节点会互相等待直到超时。
+func (g *Group) loadForPeer(ctx context.Context, key string) (ByteView, error) {
+	g.stats.loads.Add(1)
+	viewi, err, _ := g.peerLoader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		g.stats.loadsDeduped.Add(1)
+		defer func(start time.Time) { g.loadLatency.observe(time.Since(start)) }(time.Now())
+		value, err := g.getLocally(ctx, key)
+		g.countLocal(key, err)
+		if err != nil {
+			return nil, err
+		}
+		return value, nil
+	})
+	if err != nil {
+		return ByteView{}, err
+	}
+	return viewi.(ByteView), nil
+}
+
+// trackLocalLoads 向 PeerPicker 报告 n 个正在进行的本地加载，返回的函数在加载结束时调用
+func (g *Group) trackLocalLoads(n int) func() {
+	if t, ok := g.peers.(localLoadTracker); ok {
+		return t.trackLocalLoads(n)
+	}
+	return func() {}
+}
+
+// ringFingerprint 返回本节点哈希环的指纹，PeerPicker 没有实现 RingFingerprinter 时为 0
+func (g *Group) ringFingerprint() uint64 {
+	if fp, ok := g.peers.(RingFingerprinter); ok {
+		return fp.RingFingerprint()
+	}
+	return 0
+}
+
+// Set 设置 key 的值，ttl <= 0 表示永不过期。
+// 注册了 PeerPicker 时，值会被写到 key 所属的节点上，本地的旧副本被删除，
+// 并与 Remove 相同地通知其余节点删除它们 hotCache 中的旧副本。
+func (g *Group) Set(key string, value []byte, ttl time.Duration) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	view := ByteView{b: cloneBytes(value)}
+	if ttl > 0 {
+		view.e = time.Now().Add(ttl)
+	}
+	g.bloom.add(key) // 即使值写到了远程节点，本节点的过滤器也要知道 key 存在
+
+	if g.peers == nil {
+		g.populateCache(key, view)
+		return nil
+	}
+	owner, isRemote := g.peers.PickPeer(key)
+	if isRemote { // key 属于远程节点，转发给它
+		req := &pb.SetRequest{
+			Group: g.name,
+			Key: key,
+			Value: view.b,
+			Ring: g.ringFingerprint(),
+			Hops: 1,
+		}
+		if !view.e.IsZero() {
+			req.Expire = view.e.UnixNano()
+		}
+		if err := owner.Set(context.Background(), req, &pb.Response{}); err != nil {
+			return err
+		}
+		g.Invalidate(key)
+	} else {
+		g.populateCache(key, view)
+	}
+
+	// 其他节点可能在 hotCache 中缓存了旧值，让它们删除
+	req := &pb.Request{Group: g.name, Key: key, Ring: g.ringFingerprint(), Hops: 1}
+	if errs := g.removeFromPeers(req, owner); len(errs) > 0 {
+		return fmt.Errorf("set %s, but invalidating %d peers failed, first error: %v", key, len(errs), errs[0])
+	}
+	return nil
+}
+
+// Remove 在整个集群中删除 key：先删除 key 所属节点上的值，避免它被其他节点
+// 重新加载回去，再广播给其余节点，最后删除本地的副本。
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	var errs []error
+	if g.peers != nil {
+		owner, isRemote := g.peers.PickPeer(key)
+		req := &pb.Request{
+			Group: g.name,
+			Key: key,
+			Ring: g.ringFingerprint(),
+			Hops: 1,
+		}
+		if isRemote {
+			if err := owner.Remove(context.Background(), req, &pb.Response{}); err != nil {
+				return err
+			}
+		}
+
+		errs = g.removeFromPeers(req, owner)
+	}
+
+	g.Invalidate(key)
+	if len(errs) > 0 {
+		return fmt.Errorf("remove %s from %d peers failed, first error: %v", key, len(errs), errs[0])
+	}
+	return nil
+}
+
+// removeFromPeers 把 req 并发地发给 owner 以外的所有节点，让它们删除本地的副本，返回失败的错误。
+// owner 可能被包装成访问多个副本的 PeerGetter，因此按地址而不是按接口值比较
+func (g *Group) removeFromPeers(req *pb.Request, owner PeerGetter) []error {
+	ownerAddr := peerAddr(owner)
+	peers := g.peers.GetAll()
+	var wg sync.WaitGroup
+	errCh := make(chan error, len(peers))
+	for _, peer := range peers {
+		if peer == owner || (ownerAddr != "" && peerAddr(peer) == ownerAddr) {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			if err := peer.Remove(context.Background(), req, &pb.Response{}); err != nil {
+				errCh <- err
+			}
+		}(peer)
+	}
+	wg.Wait()
+	close(errCh)
+	var errs []error
+	for err := range errCh {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Invalidate 只删除本节点缓存的 key（包括 hotCache 中的副本和负缓存），不通知其他节点
+func (g *Group) Invalidate(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.negCache.remove(key)
+}
+
+// CacheType 表示 Group 中的某个缓存
+type CacheType int
+
+const (
+	// MainCache 存放本节点负责的 key
+	MainCache CacheType = iota + 1
+	// HotCache 存放其他节点负责的热点 key 的副本
+	HotCache
+	// NegativeCache 存放最近确认不存在的 key
+	NegativeCache
+)
+
+// CacheStats 返回 Group 中某个缓存的统计信息，HotCache 的 Hits 就是来自热点缓存的命中次数
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	case NegativeCache:
+		return g.negCache.stats()
+	default:
+		return CacheStats{}
+	}
+}
+
 func (g *Group) populateCache(key string, value ByteView) {
+	g.bloom.add(key)
+	g.negCache.remove(key)
 	g.mainCache.add(key, value)
 }
 
+// populatePeerValue 记录从远程节点获取到的值：计入统计，加入布隆过滤器，并采样一部分放入 hotCache
+func (g *Group) populatePeerValue(key string, value ByteView) {
+	g.stats.peerLoads.Add(1)
+	g.bloom.add(key)
+	if g.hotSampleRate > 0 && rand.Intn(g.hotSampleRate) == 0 {
+		g.hotCache.add(key, value)
+	}
+}
+
+// populateNegative 记录 key 不存在，negTTL 之后过期
+func (g *Group) populateNegative(key string) {
+	if g.negTTL > 0 {
+		g.negCache.add(key, ByteView{e: time.Now().Add(g.negTTL)})
+	}
+}
+
 // getLocally 调用用户回调函数 g.getter.Get() 获取源数据，
 // 并且将源数据添加到缓存 mainCache 中（通过 populateCache 方法）
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	defer g.trackLocalLoads(1)()
+	var (
+		bytes []byte
+		ttl   time.Duration
+		err   error
+	)
+	start := time.Now()
+	switch getter := g.getter.(type) {
+	case ContextTTLGetter:
+		bytes, ttl, err = getter.GetWithTTLContext(ctx, key)
+	case ContextGetter:
+		bytes, err = getter.GetContext(ctx, key)
+	case TTLGetter: // 数据源提供了有效期
+		bytes, ttl, err = getter.GetWithTTL(key)
+	case BatchGetter:
+		bytes, err = getOne(ctx, getter, key)
+	default:
+		bytes, err = g.getter.Get(key)
+	}
 	if err != nil {
 		return ByteView{}, err
 	}
 	value := ByteView{b: cloneBytes(bytes)}
+	if g.refresh != nil {
+		g.refresh.setDeadlines(&value, ttl, time.Since(start))
+	} else if ttl > 0 {
+		value.e = time.Now().Add(ttl)
+	}
 	g.populateCache(key, value)
 	return value, nil
 }
 
 // 新增 getFromPeer() 方法，使用实现了 PeerGetter 接口的 httpGetter 
 // 从访问远程节点，获取缓存值
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	// bytes, err := peer.Get(g.name, key) // HTTP通信
 	req := &pb.Request{
 		Group: g.name,
 		Key: key,
+		Ring: g.ringFingerprint(), // 远程节点据此发现两边的哈希环不一致
+		Hops: 1, // 远程节点只在本地处理，不会再转发
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	err := peer.Get(ctx, req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	return viewFromResponse(res), nil
 }
\ No newline at end of file
@@ -1,10 +1,20 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"testing"
 	"reflect"
+	"strconv"
+	"strings"
 	"fmt"
+	"encoding/json"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"time"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
 )
 
 // 用一个 map 模拟耗时的数据库
@@ -58,6 +68,565 @@ func TestGet(t *testing.T) {
 	}
 }
 
+// 测试带有效期的数据源，缓存值过期后重新加载
+func TestGetWithTTL(t *testing.T) {
+	loads := 0
+	gee := NewGroup("ttl-scores", 2<<10, TTLGetterFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			loads++
+			return []byte(db[key]), 20 * time.Millisecond, nil
+		}))
+
+	for i := 0; i < 2; i++ {
+		if view, err := gee.Get("Tom"); err != nil || view.String() != "630" || loads != 1 {
+			t.Fatalf("failed to get Tom from cache, loads %d", loads)
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" || loads != 2 {
+		t.Fatalf("expired Tom should be reloaded, loads %d", loads)
+	}
+}
+
+// 测试 GetContext：调用者放弃后立即返回，数据源收到的 context 随之取消
+func TestGetContext(t *testing.T) {
+	started := make(chan struct{})
+	canceled := make(chan struct{})
+	gee := NewGroup("ctx-scores", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if key != "slow" {
+				return []byte(db[key]), nil
+			}
+			close(started)
+			<-ctx.Done()
+			close(canceled)
+			return nil, ctx.Err()
+		}))
+
+	if view, err := gee.GetContext(context.Background(), "Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("failed to get Tom, err %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := gee.GetContext(ctx, "slow"); err != context.Canceled {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("getter context was not canceled")
+	}
+}
+
+// fakePeer 记录收到的请求，用来代替远程节点
+type fakePeer struct {
+	gets    int
+	sets    []*pb.SetRequest
+	removes []string
+}
+
+func (p *fakePeer) Get(_ context.Context, in *pb.Request, out *pb.Response) error {
+	p.gets++
+	v, ok := db[in.GetKey()]
+	if !ok {
+		return fmt.Errorf("%s not exist: %w", in.GetKey(), ErrNotFound)
+	}
+	out.Value = []byte(v)
+	return nil
+}
+
+func (p *fakePeer) Set(_ context.Context, in *pb.SetRequest, out *pb.Response) error {
+	p.sets = append(p.sets, in)
+	return nil
+}
+
+func (p *fakePeer) Remove(_ context.Context, in *pb.Request, out *pb.Response) error {
+	p.removes = append(p.removes, in.GetKey())
+	return nil
+}
+
+// fakePicker 把 owned 中的 key 分配给 owner，其余 key 属于本节点
+type fakePicker struct {
+	owner  *fakePeer
+	others []*fakePeer
+	owned  map[string]bool
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if p.owned[key] {
+		return p.owner, true
+	}
+	return nil, false
+}
+
+func (p *fakePicker) GetAll() []PeerGetter {
+	peers := []PeerGetter{p.owner}
+	for _, peer := range p.others {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// 测试 Set、Remove 和 Invalidate 在本地以及转发给远程节点的行为
+func TestSetRemove(t *testing.T) {
+	loads := 0
+	gee := NewGroup("set-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	picker := &fakePicker{owner: &fakePeer{}, others: []*fakePeer{{}, {}}, owned: map[string]bool{"Jack": true}}
+	gee.RegisterPeers(picker)
+
+	// 本节点拥有的 key 直接写入 mainCache
+	if err := gee.Set("Tom", []byte("700"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "700" || loads != 0 {
+		t.Fatalf("expected Tom=700 from cache, got %s, loads %d", view, loads)
+	}
+
+	// 远程节点拥有的 key 转发给所有者
+	if err := gee.Set("Jack", []byte("600"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if len(picker.owner.sets) != 1 || string(picker.owner.sets[0].GetValue()) != "600" || picker.owner.sets[0].GetExpire() == 0 {
+		t.Fatalf("Set Jack should be forwarded to owner, got %v", picker.owner.sets)
+	}
+	// Set 通知所有者以外的节点删除旧副本，本节点拥有的 Tom 通知所有节点
+	if !reflect.DeepEqual(picker.owner.removes, []string{"Tom"}) {
+		t.Fatalf("owner should only invalidate Tom, got %v", picker.owner.removes)
+	}
+	for i, peer := range picker.others {
+		if !reflect.DeepEqual(peer.removes, []string{"Tom", "Jack"}) {
+			t.Fatalf("peer %d expected invalidation of Tom and Jack, got %v", i, peer.removes)
+		}
+	}
+
+	// Remove 通知所有者和其余所有节点
+	if err := gee.Remove("Jack"); err != nil {
+		t.Fatal(err)
+	}
+	for i, peer := range append([]*fakePeer{picker.owner}, picker.others...) {
+		if n := len(peer.removes); n == 0 || peer.removes[n-1] != "Jack" {
+			t.Fatalf("peer %d expected remove of Jack, got %v", i, peer.removes)
+		}
+	}
+
+	// Invalidate 只删除本地副本，下次 Get 重新加载
+	gee.Invalidate("Tom")
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "630" || loads != 1 {
+		t.Fatalf("expected Tom reloaded after Invalidate, got %s, loads %d", view, loads)
+	}
+	if len(picker.owner.removes) != 2 {
+		t.Fatalf("Invalidate should not reach peers")
+	}
+}
+
+// wrappedPeer 把 fakePeer 包装成另一个 PeerGetter，类似 failoverGetter，接口值与 GetAll 返回的不同
+type wrappedPeer struct {
+	*fakePeer
+	addr string
+}
+
+func (p *wrappedPeer) peerAddr() string { return p.addr }
+
+// wrappedPicker 每次都返回新包装的 PeerGetter，所有者只能按地址识别
+type wrappedPicker struct {
+	owner, other *fakePeer
+}
+
+func (p *wrappedPicker) PickPeer(key string) (PeerGetter, bool) {
+	return &wrappedPeer{p.owner, "owner"}, true
+}
+
+func (p *wrappedPicker) GetAll() []PeerGetter {
+	return []PeerGetter{&wrappedPeer{p.owner, "owner"}, &wrappedPeer{p.other, "other"}}
+}
+
+// 所有者被包装过时，Remove 仍然只发给它一次
+func TestRemoveWrappedOwner(t *testing.T) {
+	gee := NewGroup("wrapped-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	picker := &wrappedPicker{owner: &fakePeer{}, other: &fakePeer{}}
+	gee.RegisterPeers(picker)
+	if err := gee.Remove("Jack"); err != nil {
+		t.Fatal(err)
+	}
+	if len(picker.owner.removes) != 1 || len(picker.other.removes) != 1 {
+		t.Fatalf("expected one remove per peer, owner %v, other %v", picker.owner.removes, picker.other.removes)
+	}
+}
+
+// 测试从远程节点获取的值被放入 hotCache，之后的请求不再访问远程节点
+func TestHotCache(t *testing.T) {
+	gee := NewGroup("hot-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			t.Fatalf("key %s should be loaded from peer", key)
+			return nil, nil
+		}), WithHotCacheSampleRate(1))
+	picker := &fakePicker{owner: &fakePeer{}, owned: map[string]bool{"Jack": true}}
+	gee.RegisterPeers(picker)
+
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get("Jack"); err != nil || view.String() != "589" {
+			t.Fatalf("failed to get Jack from peer")
+		}
+	}
+	if picker.owner.gets != 1 {
+		t.Fatalf("expected 1 peer get, got %d", picker.owner.gets)
+	}
+	if stats := gee.CacheStats(HotCache); stats.Hits != 2 || stats.Items != 1 {
+		t.Fatalf("expected 2 hot cache hits and 1 item, got %+v", stats)
+	}
+	if stats := gee.CacheStats(MainCache); stats.Items != 0 {
+		t.Fatalf("peer values should not be stored in main cache, got %+v", stats)
+	}
+
+	// Invalidate 同时删除 hotCache 中的副本
+	gee.Invalidate("Jack")
+	if _, err := gee.Get("Jack"); err != nil || picker.owner.gets != 2 {
+		t.Fatalf("expected Jack fetched from peer again, gets %d", picker.owner.gets)
+	}
+}
+
+// cacheBytes 很小时 hotCache 仍然有上限，不会变成不限制
+func TestHotCacheSmallBudget(t *testing.T) {
+	gee := NewGroup("hot-small-scores", 7, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}), WithHotCacheSampleRate(1))
+	for i := 0; i < 100; i++ {
+		gee.populatePeerValue(strconv.Itoa(i), ByteView{b: []byte("value")})
+	}
+	if s := gee.hotCache.stats(); s.Bytes > 1 {
+		t.Fatalf("hot cache uses %d bytes with cacheBytes 7", s.Bytes)
+	}
+}
+
+// 测试 httpGetter 与 HTTPPool 之间的 Set 和 Remove 请求
+func TestHTTPSetRemove(t *testing.T) {
+	gee := NewGroup("http-set-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	pool := NewHTTPPool("")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	err := getter.Set(context.Background(), &pb.SetRequest{Group: gee.name, Key: "Sam", Value: []byte("100")}, &pb.Response{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view, ok := gee.mainCache.get("Sam"); !ok || view.String() != "100" {
+		t.Fatalf("expected Sam=100 set by peer, got %s", view)
+	}
+
+	if err = getter.Remove(context.Background(), &pb.Request{Group: gee.name, Key: "Sam"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Sam"); ok {
+		t.Fatalf("expected Sam removed by peer")
+	}
+}
+
+// 测试负缓存：ErrNotFound 在 TTL 内被缓存，其他错误不缓存
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	fail := false
+	gee := NewGroup("negative-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			if fail {
+				return nil, fmt.Errorf("db is down")
+			}
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}), WithNegativeCache(20*time.Millisecond, 1<<10))
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) || loads != 1 {
+			t.Fatalf("expected cached ErrNotFound, got %v, loads %d", err, loads)
+		}
+	}
+	if stats := gee.Stats(); stats.NegativeHits != 2 || stats.NegativeCache.Items != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 过期后重新查询数据源
+	time.Sleep(30 * time.Millisecond)
+	if _, err := gee.Get("unknown"); !errors.Is(err, ErrNotFound) || loads != 2 {
+		t.Fatalf("expired negative entry should reload, loads %d", loads)
+	}
+
+	// Set 清除负缓存
+	if err := gee.Set("unknown", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get("unknown"); err != nil || view.String() != "1" || gee.CacheStats(NegativeCache).Items != 0 {
+		t.Fatalf("expected unknown=1 after Set, got %v", err)
+	}
+
+	// 其他错误不缓存
+	fail = true
+	loads = 0
+	for i := 0; i < 2; i++ {
+		if _, err := gee.Get("Jack"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected db error, got %v", err)
+		}
+	}
+	if loads != 2 {
+		t.Fatalf("errors other than ErrNotFound should not be cached, loads %d", loads)
+	}
+}
+
+// 测试远程节点返回 404 时得到 ErrNotFound，并且不再回退到本地加载
+func TestHTTPNotFound(t *testing.T) {
+	localLoads := 0
+	gee := NewGroup("http-notfound-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			localLoads++
+			return nil, ErrNotFound
+		}))
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + defaultBasePath + gee.name + "/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404, got %v", res.Status)
+	}
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	err = getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: "unknown"}, &pb.Response{})
+	if err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	// group 不存在同样是 404，但不是 ErrNotFound
+	err = getter.Get(context.Background(), &pb.Request{Group: "no-such-group", Key: "unknown"}, &pb.Response{})
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("missing group should not be ErrNotFound, got %v", err)
+	}
+
+	localLoads = 0
+	gee.peers = &fakePicker{owner: &fakePeer{}, owned: map[string]bool{"unknown": true}}
+	if _, err = gee.Get("unknown"); !errors.Is(err, ErrNotFound) || localLoads != 0 {
+		t.Fatalf("ErrNotFound from owner should not fall back to local load, err %v, loads %d", err, localLoads)
+	}
+}
+
+// 测试 AddPeers 和 RemovePeers 增量修改哈希环，已有节点的 httpGetter 保持不变
+func TestHTTPPoolMembership(t *testing.T) {
+	pool := NewHTTPPool("http://node1")
+	if moved := pool.AddPeers("http://node1", "http://node2"); len(moved) == 0 {
+		t.Fatal("adding peers to an empty pool should move every range")
+	}
+	getter := pool.httpGetters["http://node2"]
+
+	moved := pool.AddPeers("http://node3", "http://node2")
+	if len(moved) == 0 || pool.httpGetters["http://node2"] != getter {
+		t.Fatalf("AddPeers should keep existing getters, moved %d", len(moved))
+	}
+	for _, m := range moved {
+		if m.To != "http://node3" {
+			t.Fatalf("keys can only move to the new peer, got %+v", m)
+		}
+	}
+
+	// 与 Set 重建的哈希环一致
+	full := NewHTTPPool("http://node1")
+	full.Set("http://node1", "http://node2", "http://node3")
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		if pool.peers.Get(key) != full.peers.Get(key) {
+			t.Fatalf("key %s placed differently from Set", key)
+		}
+	}
+
+	moved = pool.RemovePeers("http://node3", "http://unknown")
+	for _, m := range moved {
+		if m.From != "http://node3" {
+			t.Fatalf("only keys of the removed peer can move, got %+v", m)
+		}
+	}
+	if peers := pool.Peers(); !reflect.DeepEqual(peers, []string{"http://node1", "http://node2"}) {
+		t.Fatalf("unexpected peers %v", peers)
+	}
+	if pool.RemovePeers("http://node3") != nil {
+		t.Fatal("removing an absent peer should not move keys")
+	}
+
+	// 管理接口默认关闭
+	server := httptest.NewServer(pool)
+	defer server.Close()
+	req, _ := http.NewRequest(http.MethodPost, server.URL+defaultBasePath+peersPath,
+		strings.NewReader(`{"peers":["http://node4"]}`))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden || len(pool.Peers()) != 2 {
+		t.Fatalf("expected admin endpoint disabled, got %s, peers %v", res.Status, pool.Peers())
+	}
+
+	// 开启后通过管理接口增加节点
+	WithPeerAdmin()(pool)
+	req, _ = http.NewRequest(http.MethodPost, server.URL+defaultBasePath+peersPath,
+		strings.NewReader(`{"peers":["http://node4"]}`))
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var body PeersResponse
+	if err = json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Peers) != 3 || len(body.Moved) == 0 || pool.httpGetters["http://node2"] != getter {
+		t.Fatalf("unexpected admin response %+v", body)
+	}
+}
+
+// 测试 "peer=<地址>,weight=<权重>" 形式的节点
+func TestHTTPPoolWeights(t *testing.T) {
+	pool := NewHTTPPool("http://node1")
+	pool.Set("peer=http://node1,weight=3", "http://node2", "peer=http://node3,weight=x")
+	if peers := pool.Peers(); len(peers) != 2 || peers[0] != "http://node1" || peers[1] != "http://node2" {
+		t.Fatalf("expected node1 and node2, got %v", peers)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[pool.peers.Get(strconv.Itoa(i))]++
+	}
+	if counts["http://node1"] < 2*counts["http://node2"] {
+		t.Fatalf("node1 with weight 3 should own most keys, got %v", counts)
+	}
+
+	// 已有节点再次加入时更新权重
+	if moved := pool.AddPeers("peer=http://node2,weight=3"); len(moved) == 0 || pool.weights["http://node2"] != 3 {
+		t.Fatalf("expected node2 reweighted, moved %d, weights %v", len(moved), pool.weights)
+	}
+	reweighted := NewHTTPPool("http://node1")
+	reweighted.Set("peer=http://node1,weight=3", "peer=http://node2,weight=3")
+	if pool.RingFingerprint() != reweighted.RingFingerprint() {
+		t.Fatal("reweighted ring should match one built with the same weights")
+	}
+	if pool.AddPeers("peer=http://node2,weight=3") != nil {
+		t.Fatal("re-adding a peer with the same weight should not move keys")
+	}
+
+	// AddPeers 同样支持权重，RemovePeers 使用地址或同样的写法都可以
+	pool.AddPeers("peer=http://node3,weight=2")
+	pool.RemovePeers("http://node1", "peer=http://node3,weight=2")
+	if peers := pool.Peers(); len(peers) != 1 || peers[0] != "http://node2" {
+		t.Fatalf("expected only node2 left, got %v", peers)
+	}
+	if owner := pool.peers.Get("Tom"); owner != "http://node2" {
+		t.Fatalf("all keys should belong to node2, got %s", owner)
+	}
+
+	for _, bad := range []string{"peer=,weight=1", "peer=http://a,weight=0", "peer=http://a,size=1"} {
+		if _, _, err := parsePeer(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+// 测试为 HTTPPool 选择其他的节点选择算法
+func TestHTTPPoolPlacement(t *testing.T) {
+	peers := []string{"http://node1", "http://node2", "http://node3"}
+	maglev := consistenthash.NewMaglev(0, nil)
+	maglev.Add(peers...)
+	pool := NewHTTPPool("http://node1", WithPlacement(func() consistenthash.Placement {
+		return consistenthash.NewMaglev(0, nil)
+	}))
+	pool.Set(peers...)
+	for i := 0; i < 100; i++ {
+		key := strconv.Itoa(i)
+		owner := maglev.Get(key)
+		getter, ok := pool.PickPeer(key)
+		if owner == "http://node1" {
+			if ok {
+				t.Fatalf("key %s belongs to self", key)
+			}
+			continue
+		}
+		if !ok || getter != pool.httpGetters[owner] {
+			t.Fatalf("key %s should be picked from %s", key, owner)
+		}
+	}
+	if moved := pool.AddPeers("http://node4"); moved != nil {
+		t.Fatalf("placements other than the ring report no ranges, got %v", moved)
+	}
+	if _, ok := pool.peers.(*consistenthash.Maglev); !ok {
+		t.Fatalf("AddPeers should keep the placement, got %T", pool.peers)
+	}
+
+	// Jump 不支持权重，权重被忽略
+	jump := NewHTTPPool("self", WithPlacement(func() consistenthash.Placement { return consistenthash.NewJump(nil) }))
+	jump.Set("peer=http://node1,weight=3", "http://node2")
+	if peers := jump.Peers(); len(peers) != 2 {
+		t.Fatalf("expected 2 peers, got %v", peers)
+	}
+}
+
+// 测试 Group 的统计信息以及 HTTPPool 的统计接口
+func TestStats(t *testing.T) {
+	gee := NewGroup("stats-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+	gee.Get("unknown")
+
+	stats := gee.Stats()
+	if stats.Gets != 3 || stats.CacheHits != 1 || stats.Loads != 2 || stats.LoadsDeduped != 2 ||
+		stats.LocalLoads != 1 || stats.LocalLoadErrs != 1 || stats.MainCache.Items != 1 ||
+		stats.MainCache.Bytes != int64(len("Tom")+len("630")) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	server := httptest.NewServer(NewHTTPPool(""))
+	defer server.Close()
+	res, err := http.Get(server.URL + defaultBasePath + statsPath + "/" + gee.name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var got Stats
+	if err = json.NewDecoder(res.Body).Decode(&got); err != nil || !reflect.DeepEqual(got, stats) {
+		t.Fatalf("expected stats %+v from http, got %+v (%v)", stats, got, err)
+	}
+
+	res, err = http.Get(server.URL + defaultBasePath + statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	all := make(map[string]Stats)
+	if err = json.NewDecoder(res.Body).Decode(&all); err != nil || all[gee.name].Gets != 3 {
+		t.Fatalf("expected stats of all groups, got %v (%v)", all, err)
+	}
+}
+
 // func TestGetGroup(t *testing.T) {
 // 	groupName := "scores"
 // 	NewGroup(groupName, 2<<10, GetterFunc(
@@ -69,4 +638,100 @@ func TestGet(t *testing.T) {
 // 	if group := GetGroup(groupName + "111"); group != nil {
 // 		t.Fatalf("expect nil, but %s got", group.name)
 // 	}
-// }
\ No newline at end of file
+// }
+// 其他节点转发来的请求只在本地处理，即使本节点的哈希环把 key 分配给了别的节点
+func TestPeerRequestNotForwarded(t *testing.T) {
+	var forwarded AtomicInt
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		http.Error(w, "unexpected forward", http.StatusInternalServerError)
+	}))
+	defer other.Close()
+
+	var loads AtomicInt
+	gee := NewGroup("hops-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads.Add(1)
+			return []byte(db[key]), nil
+		}))
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", "peer="+other.URL+",weight=1000") // 另一个节点的权重远大于自己，几乎所有 key 都属于它
+	gee.RegisterPeers(pool)
+	server := httptest.NewServer(pool)
+	defer server.Close()
+	for _, key := range []string{"Tom", "Sam"} {
+		if _, remote := pool.PickPeer(key); !remote {
+			t.Fatalf("expected %s to be owned by the other node", key)
+		}
+	}
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	out := &pb.Response{}
+	err := getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: "Tom", Ring: pool.RingFingerprint() + 1, Hops: 1}, out)
+	if err != nil || string(out.GetValue()) != "630" {
+		t.Fatalf("expected Tom=630 served locally, got %q, %v", out.GetValue(), err)
+	}
+	if forwarded.Get() != 0 || loads.Get() != 1 {
+		t.Fatalf("peer request forwarded %d times, loaded %d times", forwarded.Get(), loads.Get())
+	}
+	if pool.RingMismatches() != 1 {
+		t.Fatalf("expected one ring mismatch, got %d", pool.RingMismatches())
+	}
+
+	batch := &pb.BatchResponse{}
+	err = getter.GetMulti(context.Background(), &pb.BatchRequest{Group: gee.name, Keys: []string{"Jack"}, Ring: pool.RingFingerprint(), Hops: 1}, batch)
+	if err != nil || len(batch.GetValues()) != 1 {
+		t.Fatalf("expected batch served locally, got %v, %v", batch.GetValues(), err)
+	}
+	if forwarded.Get() != 0 || pool.RingMismatches() != 1 {
+		t.Fatalf("batch forwarded %d times, %d ring mismatches", forwarded.Get(), pool.RingMismatches())
+	}
+
+	err = getter.Set(context.Background(), &pb.SetRequest{Group: gee.name, Key: "Kate", Value: []byte("100"), Ring: pool.RingFingerprint() + 1, Hops: 1}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view, ok := gee.mainCache.get("Kate"); !ok || view.String() != "100" {
+		t.Fatalf("expected Kate=100 stored locally, got %s", view)
+	}
+	if forwarded.Get() != 0 || pool.RingMismatches() != 2 {
+		t.Fatalf("set forwarded %d times, %d ring mismatches", forwarded.Get(), pool.RingMismatches())
+	}
+
+	// 客户端直接发来的请求仍然转发给所属节点
+	if _, err = gee.Get("Sam"); err != nil {
+		t.Fatal(err)
+	}
+	if forwarded.Get() != 1 {
+		t.Fatalf("expected client request forwarded once, got %d", forwarded.Get())
+	}
+}
+
+// 节点列表、权重和选择节点的算法都相同时哈希环指纹相同
+func TestRingFingerprint(t *testing.T) {
+	a, b := NewHTTPPool("http://node1"), NewHTTPPool("http://node2")
+	if a.RingFingerprint() != 0 {
+		t.Fatal("empty pool should have no fingerprint")
+	}
+	a.Set("http://node1", "http://node2")
+	b.AddPeers("http://node2", "http://node1")
+	if a.RingFingerprint() == 0 || a.RingFingerprint() != b.RingFingerprint() {
+		t.Fatalf("same peers, different fingerprints %x and %x", a.RingFingerprint(), b.RingFingerprint())
+	}
+	before := a.RingFingerprint()
+	a.Set("http://node1", "peer=http://node2,weight=2")
+	if a.RingFingerprint() == before {
+		t.Fatal("weight change should change the fingerprint")
+	}
+	b.RemovePeers("http://node2")
+	if b.RingFingerprint() == before {
+		t.Fatal("removing a peer should change the fingerprint")
+	}
+	c := NewHTTPPool("http://node1", WithPlacement(func() consistenthash.Placement {
+		return consistenthash.NewJump(nil)
+	}))
+	c.Set("http://node1", "http://node2")
+	if c.RingFingerprint() == before {
+		t.Fatal("different placements should have different fingerprints")
+	}
+}
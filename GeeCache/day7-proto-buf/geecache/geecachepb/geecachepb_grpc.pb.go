@@ -19,6 +19,10 @@ const _ = grpc.SupportPackageIsVersion7
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type GroupCacheClient interface {
 	Get(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Response, error)
+	Remove(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error)
+	GetMulti(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error)
+	Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (GroupCache_TransferClient, error)
 }
 
 type groupCacheClient struct {
@@ -38,11 +42,74 @@ func (c *groupCacheClient) Get(ctx context.Context, in *Request, opts ...grpc.Ca
 	return out, nil
 }
 
+func (c *groupCacheClient) Set(ctx context.Context, in *SetRequest, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/geecachepb.GroupCache/Set", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *groupCacheClient) Remove(ctx context.Context, in *Request, opts ...grpc.CallOption) (*Response, error) {
+	out := new(Response)
+	err := c.cc.Invoke(ctx, "/geecachepb.GroupCache/Remove", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *groupCacheClient) GetMulti(ctx context.Context, in *BatchRequest, opts ...grpc.CallOption) (*BatchResponse, error) {
+	out := new(BatchResponse)
+	err := c.cc.Invoke(ctx, "/geecachepb.GroupCache/GetMulti", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *groupCacheClient) Transfer(ctx context.Context, in *TransferRequest, opts ...grpc.CallOption) (GroupCache_TransferClient, error) {
+	stream, err := c.cc.NewStream(ctx, &GroupCache_ServiceDesc.Streams[0], "/geecachepb.GroupCache/Transfer", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &groupCacheTransferClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GroupCache_TransferClient interface {
+	Recv() (*Entry, error)
+	grpc.ClientStream
+}
+
+type groupCacheTransferClient struct {
+	grpc.ClientStream
+}
+
+func (x *groupCacheTransferClient) Recv() (*Entry, error) {
+	m := new(Entry)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // GroupCacheServer is the server API for GroupCache service.
 // All implementations must embed UnimplementedGroupCacheServer
 // for forward compatibility
 type GroupCacheServer interface {
 	Get(context.Context, *Request) (*Response, error)
+	Set(context.Context, *SetRequest) (*Response, error)
+	Remove(context.Context, *Request) (*Response, error)
+	GetMulti(context.Context, *BatchRequest) (*BatchResponse, error)
+	Transfer(*TransferRequest, GroupCache_TransferServer) error
 	mustEmbedUnimplementedGroupCacheServer()
 }
 
@@ -53,6 +120,18 @@ type UnimplementedGroupCacheServer struct {
 func (UnimplementedGroupCacheServer) Get(context.Context, *Request) (*Response, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
 }
+func (UnimplementedGroupCacheServer) Set(context.Context, *SetRequest) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Set not implemented")
+}
+func (UnimplementedGroupCacheServer) Remove(context.Context, *Request) (*Response, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Remove not implemented")
+}
+func (UnimplementedGroupCacheServer) GetMulti(context.Context, *BatchRequest) (*BatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMulti not implemented")
+}
+func (UnimplementedGroupCacheServer) Transfer(*TransferRequest, GroupCache_TransferServer) error {
+	return status.Errorf(codes.Unimplemented, "method Transfer not implemented")
+}
 func (UnimplementedGroupCacheServer) mustEmbedUnimplementedGroupCacheServer() {}
 
 // UnsafeGroupCacheServer may be embedded to opt out of forward compatibility for this service.
@@ -84,6 +163,81 @@ func _GroupCache_Get_Handler(srv interface{}, ctx context.Context, dec func(inte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _GroupCache_Set_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GroupCacheServer).Set(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/geecachepb.GroupCache/Set",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GroupCacheServer).Set(ctx, req.(*SetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GroupCache_Remove_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GroupCacheServer).Remove(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/geecachepb.GroupCache/Remove",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GroupCacheServer).Remove(ctx, req.(*Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GroupCache_GetMulti_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GroupCacheServer).GetMulti(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/geecachepb.GroupCache/GetMulti",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GroupCacheServer).GetMulti(ctx, req.(*BatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GroupCache_Transfer_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TransferRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GroupCacheServer).Transfer(m, &groupCacheTransferServer{stream})
+}
+
+type GroupCache_TransferServer interface {
+	Send(*Entry) error
+	grpc.ServerStream
+}
+
+type groupCacheTransferServer struct {
+	grpc.ServerStream
+}
+
+func (x *groupCacheTransferServer) Send(m *Entry) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // GroupCache_ServiceDesc is the grpc.ServiceDesc for GroupCache service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -95,7 +249,25 @@ var GroupCache_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Get",
 			Handler:    _GroupCache_Get_Handler,
 		},
+		{
+			MethodName: "Set",
+			Handler:    _GroupCache_Set_Handler,
+		},
+		{
+			MethodName: "Remove",
+			Handler:    _GroupCache_Remove_Handler,
+		},
+		{
+			MethodName: "GetMulti",
+			Handler:    _GroupCache_GetMulti_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Transfer",
+			Handler:       _GroupCache_Transfer_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "geecachepb.proto",
 }
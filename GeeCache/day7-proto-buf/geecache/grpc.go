@@ -0,0 +1,384 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCPool 为一个 gRPC 对等体池实现了 PeerPicker，并通过 Register 提供 GroupCacheServer 服务。
+// 它与 HTTPPool 使用同样的一致性哈希选择节点，节点列表相同时两者把 key 分配给同一个节点。
+// 调用 EnableMigration 后，节点变化时与 HTTPPool 相同地通过 Transfer 迁移 key。
+type GRPCPool struct {
+	self        string                 // 自己的地址，例如 "localhost:9001"
+	dialOpts    []grpc.DialOption      // 连接远程节点的选项
+	mu          sync.Mutex             // guards peers, grpcGetters and fingerprint
+	peers       *consistenthash.Map    // 根据 key 选择节点
+	grpcGetters map[string]*grpcGetter // keyed by e.g. "10.0.0.2:9001"
+	fingerprint uint64                 // 哈希环的指纹，与节点列表相同的 HTTPPool 一致
+
+	ringMismatches AtomicInt // 远程节点发来的请求中哈希环指纹与本节点不一致的次数
+	migrator       migrator  // 本节点参与的迁移
+}
+
+// NewGRPCPool 初始化 gRPC 对等体池，没有传入 dialOpts 时使用不加密的连接
+func NewGRPCPool(self string, dialOpts ...grpc.DialOption) *GRPCPool {
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	p := &GRPCPool{
+		self:        self,
+		dialOpts:    dialOpts,
+		grpcGetters: make(map[string]*grpcGetter),
+	}
+	p.migrator.self = self
+	p.migrator.log = p.Log
+	return p
+}
+
+// EnableMigration 开启节点变化时的 key 迁移，与 HTTPPool 的 WithMigration 相同，需要在 Set 之前调用。
+// 新的所有者通过 Transfer 流从原来的所有者拉取记录，进度可以通过 Migrations 查看
+func (p *GRPCPool) EnableMigration(limit int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.migrator.enabled = true
+	p.migrator.limit = limit
+}
+
+// Log 打印服务端名字
+func (p *GRPCPool) Log(format string, v ...interface{}) {
+	log.Printf("[gRPC Server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// Register 把本节点的 GroupCacheServer 注册到 gRPC 服务上
+func (p *GRPCPool) Register(s grpc.ServiceRegistrar) {
+	pb.RegisterGroupCacheServer(s, &grpcServer{pool: p})
+}
+
+// Set 更新节点列表。仍在列表中的节点复用已有的连接，被移除节点的连接会被关闭。
+// 与 HTTPPool 相同，节点可以写成 "peer=<地址>,weight=<权重>" 的形式。
+// 开启了迁移时，本节点从原来的所有者拉取新分到的区间内的记录。
+func (p *GRPCPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var before []consistenthash.Range
+	if p.peers != nil {
+		before = p.peers.Ranges()
+	}
+	p.peers = consistenthash.New(defaultReplicas, nil)
+	getters := make(map[string]*grpcGetter, len(peers))
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		peer, weight, err := parsePeer(peer)
+		if err != nil {
+			p.Log("ignoring peer: %v", err)
+			continue
+		}
+		p.peers.AddWeighted(peer, weight)
+		weights[peer] = weight
+		if peer == p.self {
+			continue
+		}
+		if getter, ok := p.grpcGetters[peer]; ok {
+			getters[peer] = getter
+			continue
+		}
+		conn, err := grpc.Dial(peer, p.dialOpts...) // 不会阻塞，连接在第一次请求时建立
+		if err != nil {
+			p.Log("dial %s: %v", peer, err)
+			continue
+		}
+		getters[peer] = &grpcGetter{addr: peer, conn: conn, client: pb.NewGroupCacheClient(conn)}
+	}
+	for peer, getter := range p.grpcGetters {
+		if _, ok := getters[peer]; !ok {
+			getter.conn.Close()
+		}
+	}
+	p.grpcGetters = getters
+	p.fingerprint = ringFingerprint(fmt.Sprintf("%T", p.peers), weights)
+	if before != nil {
+		p.migrator.start(consistenthash.Moved(before, p.peers.Ranges()), p.dialMigration)
+	}
+}
+
+// dialMigration 返回从 peer 拉取记录的客户端。peer 已经被移除时单独建立连接，拉取结束后关闭。
+// 调用方需持有 p.mu
+func (p *GRPCPool) dialMigration(peer string) (PeerGetter, *AtomicInt, func()) {
+	if getter, ok := p.grpcGetters[peer]; ok {
+		return getter, nil, nil
+	}
+	conn, err := grpc.Dial(peer, p.dialOpts...)
+	if err != nil {
+		p.Log("dial %s: %v", peer, err)
+		return nil, nil, nil
+	}
+	return &grpcGetter{addr: peer, conn: conn, client: pb.NewGroupCacheClient(conn)}, nil, func() { conn.Close() }
+}
+
+// RingFingerprint 返回哈希环的指纹，实现了 RingFingerprinter
+func (p *GRPCPool) RingFingerprint() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fingerprint
+}
+
+// checkRing 比较请求方的哈希环指纹，不一致时记录日志并计数。ring 为 0 表示请求方没有提供
+func (p *GRPCPool) checkRing(ring uint64, group, key string) {
+	if ring == 0 {
+		return
+	}
+	if local := p.RingFingerprint(); ring != local {
+		p.ringMismatches.Add(1)
+		p.Log("ring fingerprint mismatch on %s/%s: peer %016x, local %016x", group, key, ring, local)
+	}
+}
+
+// RingMismatches 返回远程节点发来的请求中哈希环指纹与本节点不一致的次数
+func (p *GRPCPool) RingMismatches() int64 {
+	return p.ringMismatches.Get()
+}
+
+// PickPeer 根据 key 选择节点，返回节点对应的 gRPC 客户端
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if getter, ok := p.migrator.route(p.peers, key); ok { // 迁移完成前，key 仍然由原来的所有者处理
+		return getter, getter != nil
+	}
+	if peer := p.peers.Get(key); peer != "" && peer != p.self {
+		getter, ok := p.grpcGetters[peer]
+		if !ok { // 连接失败的节点没有客户端。不能返回 nil 的 *grpcGetter，它在 PeerGetter 接口中不是 nil
+			return nil, false
+		}
+		p.Log("pick peer %s", peer)
+		return getter, true
+	}
+	return nil, false
+}
+
+// GetAll 返回除自己以外所有节点的 gRPC 客户端
+func (p *GRPCPool) GetAll() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]PeerGetter, 0, len(p.grpcGetters))
+	for _, getter := range p.grpcGetters {
+		peers = append(peers, getter)
+	}
+	return peers
+}
+
+// Close 关闭所有到远程节点的连接
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for peer, getter := range p.grpcGetters {
+		getter.conn.Close()
+		delete(p.grpcGetters, peer)
+	}
+	return nil
+}
+
+var (
+	_ PeerPicker        = (*GRPCPool)(nil)
+	_ RingFingerprinter = (*GRPCPool)(nil)
+)
+
+// lookupGroup 找到请求对应的 group，不存在时返回 NotFound
+func lookupGroup(name string) (*Group, error) {
+	group := GetGroup(name)
+	if group == nil {
+		return nil, status.Errorf(codes.NotFound, "no such group: %s", name)
+	}
+	return group, nil
+}
+
+// errNotFoundStatus 是 key 不存在时返回的状态，消息与 ErrNotFound 相同，
+// 以便和 group 不存在时的 codes.NotFound 区分
+var errNotFoundStatus = status.Error(codes.NotFound, ErrNotFound.Error())
+
+// grpcServer 实现了 GroupCacheServer，处理其他节点发来的请求
+type grpcServer struct {
+	pb.UnimplementedGroupCacheServer
+	pool *GRPCPool
+}
+
+// Get 通过 GetGroup(...).Get 获取缓存值，其他节点转发来的请求只在本地处理，不再转发
+func (s *grpcServer) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	s.pool.Log("Get %s/%s", in.GetGroup(), in.GetKey())
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	s.pool.checkRing(in.GetRing(), in.GetGroup(), in.GetKey())
+	var view ByteView
+	if in.GetHops() > 0 {
+		view, err = group.getForPeer(ctx, in.GetKey())
+	} else {
+		view, err = group.GetContext(ctx, in.GetKey())
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, errNotFoundStatus
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return newResponse(view), nil
+}
+
+// Set 保存远程发来的值，hops > 0 时只写入本地
+func (s *grpcServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.Response, error) {
+	s.pool.Log("Set %s/%s", in.GetGroup(), in.GetKey())
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	s.pool.checkRing(in.GetRing(), in.GetGroup(), in.GetKey())
+	if err = group.setFromPeer(in); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Response{}, nil
+}
+
+// Remove 删除 key。hops > 0 时只删除本节点的副本，广播由发起方负责；否则在整个集群中删除
+func (s *grpcServer) Remove(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	s.pool.Log("Remove %s/%s", in.GetGroup(), in.GetKey())
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	s.pool.checkRing(in.GetRing(), in.GetGroup(), in.GetKey())
+	if in.GetHops() > 0 {
+		group.invalidateFromPeer(in.GetKey())
+		return &pb.Response{}, nil
+	}
+	if err = group.Remove(in.GetKey()); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &pb.Response{}, nil
+}
+
+// GetMulti 批量获取缓存值，不存在的 key 放在 NotFound 中
+func (s *grpcServer) GetMulti(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	s.pool.Log("GetMulti %s, %d keys", in.GetGroup(), len(in.GetKeys()))
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	s.pool.checkRing(in.GetRing(), in.GetGroup(), fmt.Sprintf("%d keys", len(in.GetKeys())))
+	return group.batchResponse(ctx, in), nil
+}
+
+// Transfer 以流的形式发送 mainCache 中哈希值落在请求区间内的记录，最热的在前，供新的所有者预热缓存
+func (s *grpcServer) Transfer(in *pb.TransferRequest, stream pb.GroupCache_TransferServer) error {
+	s.pool.Log("Transfer %s to %s, %d ranges", in.GetGroup(), in.GetOwner(), len(in.GetRanges()))
+	group, err := lookupGroup(in.GetGroup())
+	if err != nil {
+		return err
+	}
+	s.pool.mu.Lock()
+	peers := s.pool.peers
+	s.pool.mu.Unlock()
+	if peers == nil {
+		return status.Error(codes.FailedPrecondition, errTransferNeedsRing.Error())
+	}
+	return s.pool.migrator.serve(group, peers, in, stream.Send)
+}
+
+var _ pb.GroupCacheServer = (*grpcServer)(nil)
+
+// grpcGetter 通过一个复用的 grpc.ClientConn 访问远程节点，实现了 PeerGetter
+type grpcGetter struct {
+	addr   string
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+}
+
+// peerAddr 返回节点的地址，实现了 peerAddresser
+func (g *grpcGetter) peerAddr() string {
+	return g.addr
+}
+
+func (g *grpcGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	res, err := g.client.Get(ctx, in)
+	if err != nil {
+		if s, ok := status.FromError(err); ok && s.Code() == codes.NotFound && s.Message() == ErrNotFound.Error() {
+			return ErrNotFound
+		}
+		return err
+	}
+	out.Reset()
+	proto.Merge(out, res)
+	return nil
+}
+
+func (g *grpcGetter) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	res, err := g.client.Set(ctx, in)
+	if err != nil {
+		return err
+	}
+	out.Reset()
+	proto.Merge(out, res)
+	return nil
+}
+
+func (g *grpcGetter) Remove(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	res, err := g.client.Remove(ctx, in)
+	if err != nil {
+		return err
+	}
+	out.Reset()
+	proto.Merge(out, res)
+	return nil
+}
+
+func (g *grpcGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	res, err := g.client.GetMulti(ctx, in)
+	if err != nil {
+		return err
+	}
+	out.Reset()
+	proto.Merge(out, res)
+	return nil
+}
+
+// Transfer 接收远程节点以流的形式发送的迁移记录，对每条记录调用 fn，实现了 TransferPeer
+func (g *grpcGetter) Transfer(ctx context.Context, in *pb.TransferRequest, fn func(*pb.Entry) error) error {
+	stream, err := g.client.Transfer(ctx, in)
+	if err != nil {
+		return err
+	}
+	for {
+		e, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+var (
+	_ PeerGetter      = (*grpcGetter)(nil)
+	_ BatchPeerGetter = (*grpcGetter)(nil)
+	_ TransferPeer    = (*grpcGetter)(nil)
+	_ peerAddresser   = (*grpcGetter)(nil)
+)
@@ -0,0 +1,205 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	pb "geecache/geecachepb"
+
+	"google.golang.org/grpc"
+)
+
+// startGRPCPool 在随机端口上启动一个 gRPC 节点
+func startGRPCPool(t *testing.T) (*GRPCPool, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	pool := NewGRPCPool(addr)
+	server := grpc.NewServer()
+	pool.Register(server)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return pool, addr
+}
+
+func TestGRPCPool(t *testing.T) {
+	gee := NewGroup("grpc-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+	server, addr := startGRPCPool(t)
+	server.Set(addr)
+
+	client := NewGRPCPool("client")
+	defer client.Close()
+	client.Set(addr)
+	peer, ok := client.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("expected Tom owned by %s", addr)
+	}
+
+	out := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: gee.name, Key: "Tom"}, out); err != nil || string(out.GetValue()) != "630" {
+		t.Fatalf("expected Tom=630 over gRPC, got %q (%v)", out.GetValue(), err)
+	}
+	if err := peer.Get(context.Background(), &pb.Request{Group: gee.name, Key: "unknown"}, out); err == nil {
+		t.Fatalf("expected error for unknown key")
+	}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "no-such-group", Key: "Tom"}, out); err == nil {
+		t.Fatalf("expected error for unknown group")
+	}
+
+	batch := &pb.BatchResponse{}
+	err := peer.(BatchPeerGetter).GetMulti(context.Background(), &pb.BatchRequest{Group: gee.name, Keys: []string{"Tom", "Jack", "unknown"}}, batch)
+	if err != nil || len(batch.GetValues()) != 2 || string(batch.GetValues()["Jack"].GetValue()) != "589" {
+		t.Fatalf("unexpected batch response %v (%v)", batch, err)
+	}
+
+	// 迁移整个哈希环时发送所有缓存的记录
+	var keys []string
+	err = peer.(TransferPeer).Transfer(context.Background(), &pb.TransferRequest{Group: gee.name, Ranges: []*pb.KeyRange{{Start: 0, End: math.MaxUint64}}}, func(e *pb.Entry) error {
+		keys = append(keys, e.GetKey())
+		return nil
+	})
+	sort.Strings(keys)
+	if err != nil || !reflect.DeepEqual(keys, []string{"Jack", "Tom"}) {
+		t.Fatalf("expected Jack and Tom transferred, got %v (%v)", keys, err)
+	}
+
+	expire := time.Now().Add(time.Minute).UnixNano()
+	set := &pb.SetRequest{Group: gee.name, Key: "Sam", Value: []byte("100"), Expire: expire, Ring: server.RingFingerprint() + 1, Hops: 1}
+	if err := peer.Set(context.Background(), set, out); err != nil {
+		t.Fatal(err)
+	}
+	if view, ok := gee.mainCache.get("Sam"); !ok || view.String() != "100" || view.Expire().UnixNano() != expire {
+		t.Fatalf("expected Sam=100 set over gRPC, got %s", view)
+	}
+	if server.RingMismatches() != 1 {
+		t.Fatalf("expected one ring mismatch, got %d", server.RingMismatches())
+	}
+	metrics := httptest.NewServer(http.HandlerFunc(server.ServeMetrics))
+	defer metrics.Close()
+	if got := scrape(t, metrics.URL)["geecache_ring_mismatches_total"]; got != 1 {
+		t.Fatalf("expected geecache_ring_mismatches_total 1, got %v", got)
+	}
+	if err := peer.Remove(context.Background(), &pb.Request{Group: gee.name, Key: "Sam"}, out); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.mainCache.get("Sam"); ok {
+		t.Fatalf("expected Sam removed over gRPC")
+	}
+}
+
+// 节点列表相同时，GRPCPool 与 HTTPPool 把每个 key 分配给同一个节点
+func TestGRPCPoolRouting(t *testing.T) {
+	peers := []string{"node1", "node2", "node3"}
+	httpPool := NewHTTPPool("node1")
+	httpPool.Set(peers...)
+	grpcPool := NewGRPCPool("node1")
+	grpcPool.Set(peers...)
+	defer grpcPool.Close()
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		httpPeer, httpRemote := httpPool.PickPeer(key)
+		grpcPeer, grpcRemote := grpcPool.PickPeer(key)
+		if httpRemote != grpcRemote {
+			t.Fatalf("%s: http remote %v, grpc remote %v", key, httpRemote, grpcRemote)
+		}
+		if httpRemote && httpPeer.(*httpGetter).baseURL != grpcPeer.(*grpcGetter).addr+defaultBasePath {
+			t.Fatalf("%s: http picks %s, grpc picks %s", key, httpPeer.(*httpGetter).baseURL, grpcPeer.(*grpcGetter).addr)
+		}
+	}
+
+	if httpPool.RingFingerprint() != grpcPool.RingFingerprint() {
+		t.Fatalf("http fingerprint %x, grpc fingerprint %x", httpPool.RingFingerprint(), grpcPool.RingFingerprint())
+	}
+
+	// 没有客户端的节点返回 nil 接口，而不是 nil 的 *grpcGetter
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key%d", i); grpcPool.peers.Get(k) == "node3" {
+			key = k
+		}
+	}
+	conn := grpcPool.grpcGetters["node3"]
+	delete(grpcPool.grpcGetters, "node3")
+	if getter, ok := grpcPool.PickPeer(key); ok || getter != nil {
+		t.Fatalf("expected no getter for %s without a client, got %#v, %v", key, getter, ok)
+	}
+	grpcPool.grpcGetters["node3"] = conn
+
+	// 更新节点列表后，仍然存在的节点复用原来的连接
+	before := grpcPool.grpcGetters["node2"]
+	grpcPool.Set("node1", "node2")
+	if grpcPool.grpcGetters["node2"] != before || len(grpcPool.grpcGetters) != 1 {
+		t.Fatalf("expected connection to node2 reused")
+	}
+}
+
+// transferServer 是只实现了 Transfer 的 gRPC 节点，发送 group 的 entries
+type transferServer struct {
+	pb.UnimplementedGroupCacheServer
+	group   string
+	entries []*pb.Entry
+}
+
+func (s *transferServer) Transfer(in *pb.TransferRequest, stream pb.GroupCache_TransferServer) error {
+	if in.GetGroup() != s.group { // 其他 group 没有记录
+		return nil
+	}
+	for _, e := range s.entries {
+		if err := stream.Send(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GRPCPool 开启迁移后，新的所有者通过 Transfer 流从原来的所有者拉取记录
+func TestGRPCPoolMigration(t *testing.T) {
+	gee := NewGroup("grpc-migration-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterGroupCacheServer(server, &transferServer{group: gee.name, entries: []*pb.Entry{{Key: "Tom", Value: []byte("630")}}})
+	go server.Serve(lis)
+	defer server.Stop()
+	old := lis.Addr().String()
+
+	pool := NewGRPCPool("new")
+	defer pool.Close()
+	pool.EnableMigration(0)
+	pool.Set(old)
+	pool.Set(old, "new")
+
+	stats := pool.Migrations()
+	if len(stats) != 1 || stats[0].Peer != old || stats[0].Direction != "in" {
+		t.Fatalf("unexpected migration stats %+v", stats)
+	}
+	waitFor(t, func() bool { return pool.Migrations()[0].State != migrationRunning })
+	if stats = pool.Migrations(); stats[0].State != migrationDone || stats[0].Keys != 1 {
+		t.Fatalf("expected migration done with 1 key, got %+v", stats[0])
+	}
+	if v, ok := gee.mainCache.get("Tom"); !ok || v.String() != "630" {
+		t.Fatalf("expected Tom migrated over gRPC, got %q", v)
+	}
+}
@@ -1,14 +1,23 @@
 package geecache
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"geecache/consistenthash"
+	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 	pb "geecache/geecachepb"
 	"github.com/golang/protobuf/proto"
 )
@@ -16,26 +25,179 @@ import (
 const (
 	defaultBasePath = "/_geecache/"
 	defaultReplicas = 50
+	// statsPath 是统计信息的路径，位于 basePath 之下，因此 group 不能以它命名
+	statsPath = "_stats"
+	// peersPath 是节点管理接口：GET 返回各个远程节点的请求统计和熔断器状态，
+	// 开启 WithPeerAdmin 后 POST 和 DELETE 增加或删除节点。同样不能作为 group 名
+	peersPath = "_peers"
+	// bloomPath 返回序列化后的布隆过滤器：<basepath>/_bloom/<groupname>，同样不能作为 group 名
+	bloomPath = "_bloom"
+	// batchPath 是批量获取接口：POST <basepath>/_batch/<groupname>，请求体是 pb.BatchRequest，同样不能作为 group 名
+	batchPath = "_batch"
+	// transferPath 是迁移接口：POST <basepath>/_transfer/<groupname>，请求体是 pb.TransferRequest，
+	// 响应是一串带长度前缀的 pb.Entry，同样不能作为 group 名
+	transferPath = "_transfer"
+	// migrationsPath 以 JSON 格式返回本节点参与的迁移的进度，同样不能作为 group 名
+	migrationsPath = "_migrations"
+	// notFoundHeader 标记 404 是因为 key 不存在（ErrNotFound），而不是 group 不存在
+	notFoundHeader = "X-Geecache-Not-Found"
+
+	defaultPeerTimeout      = 5 * time.Second
+	defaultRetries          = 2
+	defaultRetryBackoff     = 50 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+	defaultReplication      = 1
 )
 
 // HTTPPool为一个HTTP对等体池实现了PeerPicker。
 type HTTPPool struct {
 	// peer的基本URL，例如: “https://example.net:8000”
-	self        string                 // 用来记录自己的地址, 包括主机名/IP 和端口
-	basePath    string                 // 作为节点间通讯地址的前缀，默认是 /_geecache/
-	mu          sync.Mutex             // guards peers and httpGetters
-	peers       *consistenthash.Map    // 新增成员变量 peers，类型是一致性哈希算法的 Map，用来根据具体的 key 选择节点
-	httpGetters map[string]*httpGetter // keyed by e.g. "http://10.0.0.2:8008"
+	self        string                   // 用来记录自己的地址, 包括主机名/IP 和端口
+	basePath    string                   // 作为节点间通讯地址的前缀，默认是 /_geecache/
+	mu          sync.Mutex               // guards peers and httpGetters
+	peers       consistenthash.Placement // 新增成员变量 peers，默认是一致性哈希算法的 Map，用来根据具体的 key 选择节点
+	httpGetters map[string]*httpGetter   // keyed by e.g. "http://10.0.0.2:8008"
 	// 新增成员变量 httpGetters，映射远程节点与对应的 httpGetter
 	// 每一个远程节点对应一个 httpGetter，因为 httpGetter 与远程节点的地址 baseURL 有关。
+	peerStates map[string]*peerState // 访问各个远程节点的统计和熔断器，节点被删除或 Set 重建时保留
+
+	client           *http.Client                    // 访问远程节点使用的 HTTP 客户端
+	timeout          time.Duration                   // 每次请求的超时时间，0 表示不限制
+	retries          int                             // 失败后最多重试的次数
+	backoff          time.Duration                   // 第一次重试前的等待时间，之后每次翻倍
+	breakerThreshold int                             // 连续失败多少次后熔断，<= 0 表示不熔断
+	breakerCooldown  time.Duration                   // 熔断后多久再次尝试
+	replication      int                             // 每个 key 的副本节点数，主节点不可用时依次访问后面的副本
+	loadEpsilon      float64                         // 有界负载模式下允许超出平均负载的比例，0 表示关闭
+	newPlacement     func() consistenthash.Placement // 创建选择节点的算法，nil 表示使用哈希环
+	peerAdmin        bool                            // 是否允许通过 <basepath>/_peers 增删节点
+
+	failovers      map[string]*failoverGetter // 按候选节点列表复用，哈希环变化时清空
+	migrator       migrator                   // 本节点参与的迁移
+	weights        map[string]int             // 各节点的权重，用来计算哈希环的指纹
+	fingerprint    uint64                     // 哈希环的指纹，随请求发给远程节点
+	ringMismatches AtomicInt                  // 远程节点发来的请求中哈希环指纹与本节点不一致的次数
+	localInflight  AtomicInt                  // 本节点正在进行的本地加载，有界负载模式下作为自己的负载
+}
+
+// peerState 记录访问一个远程节点的情况
+type peerState struct {
+	latency   *histogram
+	breaker   *breaker
+	requests  AtomicInt
+	retries   AtomicInt
+	errors    AtomicInt
+	failovers AtomicInt // 因为该节点不可用而改为访问下一个副本的请求
+	inflight  AtomicInt // 正在进行的请求，有界负载模式据此选择节点
+	migrated  AtomicInt // 迁移时从该节点接收的记录
+}
+
+// HTTPPoolOption 是 NewHTTPPool 的可选配置
+type HTTPPoolOption func(*HTTPPool)
+
+// WithHTTPClient 设置访问远程节点使用的 HTTP 客户端，默认为 http.DefaultClient
+func WithHTTPClient(client *http.Client) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.client = client
+	}
+}
+
+// WithPeerTimeout 设置每次请求远程节点的超时时间，重试的每次请求单独计时，0 表示不限制
+func WithPeerTimeout(timeout time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.timeout = timeout
+	}
+}
+
+// WithRetries 设置请求失败（网络错误、超时或 502/503/504）后最多重试的次数，
+// 第 n 次重试前等待 backoff * 2^(n-1)，并加上随机抖动
+func WithRetries(retries int, backoff time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.retries = retries
+		p.backoff = backoff
+	}
+}
+
+// WithCircuitBreaker 设置熔断器：连续 threshold 次请求失败后，cooldown 时间内
+// PickPeer 不再选择该节点，由本地加载。threshold <= 0 时关闭熔断。
+func WithCircuitBreaker(threshold int, cooldown time.Duration) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.breakerThreshold = threshold
+		p.breakerCooldown = cooldown
+	}
+}
+
+// WithReplication 设置每个 key 的副本数 n：key 属于哈希环上顺时针的前 n 个不同节点，
+// 请求先发给第一个节点，它不可用（网络错误、超时、网关错误或熔断）时依次改为访问后面的节点，
+// 避免一个节点故障后所有节点都回退到本地加载，数据源承受全部压力。
+// 副本中包含本节点时，前面的节点都不可用后由本地加载。默认为 1，不做故障转移。
+func WithReplication(n int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.replication = n
+	}
+}
+
+// WithBoundedLoad 开启有界负载的一致性哈希：PickPeer 统计发往每个节点、尚未完成的请求数，
+// 本节点的负载是正在进行的本地加载数，
+// 节点的负载超过 (1+epsilon) 倍平均值时，key 交给哈希环上顺时针的下一个节点。
+// 热点 key 集中的节点因此不会被压垮，代价是部分 key 会被其他节点加载和缓存。epsilon <= 0 时关闭。
+func WithBoundedLoad(epsilon float64) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.loadEpsilon = epsilon
+	}
+}
+
+// WithPlacement 设置选择节点的算法，newPlacement 在每次 Set 重建节点列表时调用，例如
+// consistenthash.NewRendezvous、NewJump 或 NewMaglev。默认是 50 个虚拟节点的哈希环。
+// 只有哈希环支持有界负载，AddPeers 和 RemovePeers 也只有在哈希环上才返回移动的区间。
+// 所有节点必须使用同样的算法，否则它们对 key 的归属判断不一致。
+func WithPlacement(newPlacement func() consistenthash.Placement) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.newPlacement = newPlacement
+	}
+}
+
+// WithMigration 开启节点变化时的 key 迁移：Set、AddPeers 或 RemovePeers 改变哈希环后，
+// 本节点通过 Transfer 从原来的所有者拉取新分到的区间内最热的缓存记录，每个 group 最多 limit 条，
+// limit <= 0 表示不限制。拉取完成之前这些 key 仍然交给原来的所有者处理，避免新节点大量未命中、
+// 数据源承受突发的加载。只支持哈希环，原来的所有者的淘汰策略需要实现 RangePolicy。
+// 进度可以通过 <basepath>/_migrations 查看。GRPCPool 通过 EnableMigration 开启同样的迁移。
+func WithMigration(limit int) HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.migrator.enabled = true
+		p.migrator.limit = limit
+	}
+}
+
+// WithPeerAdmin 允许通过 <basepath>/_peers 的 POST 和 DELETE 请求增删节点。
+// 该接口没有鉴权，能访问缓存端口的任何人都可以修改哈希环，默认关闭，
+// 开启时应确保缓存端口只对可信的网络开放
+func WithPeerAdmin() HTTPPoolOption {
+	return func(p *HTTPPool) {
+		p.peerAdmin = true
+	}
 }
 
 // NewHTTPPool初始化对等体的HTTP池。
-func NewHTTPPool(self string) *HTTPPool {
-	return &HTTPPool{
-		self:     self,
-		basePath: defaultBasePath,
+func NewHTTPPool(self string, opts ...HTTPPoolOption) *HTTPPool {
+	p := &HTTPPool{
+		self:             self,
+		basePath:         defaultBasePath,
+		client:           http.DefaultClient,
+		timeout:          defaultPeerTimeout,
+		retries:          defaultRetries,
+		backoff:          defaultRetryBackoff,
+		breakerThreshold: defaultBreakerThreshold,
+		breakerCooldown:  defaultBreakerCooldown,
+		replication:      defaultReplication,
+	}
+	p.migrator.self = self
+	p.migrator.log = p.Log
+	for _, opt := range opts {
+		opt(p)
 	}
+	return p
 }
 
 // Log 打印服务端名字
@@ -51,6 +213,32 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	p.Log("%s %s", r.Method, r.URL.Path)
 
+	// <basepath>/_stats 返回所有 group 的统计信息，<basepath>/_stats/<groupname> 返回单个 group 的
+	if rest := r.URL.Path[len(p.basePath):]; rest == statsPath || strings.HasPrefix(rest, statsPath+"/") {
+		p.serveStats(w, strings.TrimPrefix(strings.TrimPrefix(rest, statsPath), "/"))
+		return
+	}
+	if r.URL.Path[len(p.basePath):] == peersPath {
+		p.servePeers(w, r)
+		return
+	}
+	if rest := r.URL.Path[len(p.basePath):]; strings.HasPrefix(rest, bloomPath+"/") {
+		p.serveBloom(w, strings.TrimPrefix(rest, bloomPath+"/"))
+		return
+	}
+	if rest := r.URL.Path[len(p.basePath):]; strings.HasPrefix(rest, batchPath+"/") {
+		p.serveBatch(w, r, strings.TrimPrefix(rest, batchPath+"/"))
+		return
+	}
+	if rest := r.URL.Path[len(p.basePath):]; strings.HasPrefix(rest, transferPath+"/") {
+		p.serveTransfer(w, r, strings.TrimPrefix(rest, transferPath+"/"))
+		return
+	}
+	if r.URL.Path[len(p.basePath):] == migrationsPath {
+		p.serveMigrations(w)
+		return
+	}
+
 	// 约定访问路径格式
 	// /<basepath>/<groupname>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2)
@@ -68,7 +256,40 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	view, err := group.Get(key) // 使用 group.Get(key) 获取缓存数据
+	// 其他节点发来的请求带有哈希环指纹和经过的节点数
+	ring, hops := parsePeerQuery(r.URL.Query())
+	p.checkRing(ring, groupname, key)
+
+	// 根据请求方法区分读取、写入和删除
+	switch r.Method {
+	case http.MethodPut:
+		p.serveSet(w, r, group, key)
+		return
+	case http.MethodDelete:
+		if hops > 0 { // 其他节点发来的通知只删除本节点的副本，广播由发起方负责
+			group.invalidateFromPeer(key)
+			return
+		}
+		if err := group.Remove(key); err != nil { // 客户端直接发来的删除与 Set 相同，在整个集群中删除
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// 使用 group.GetContext() 获取缓存数据，请求方断开后加载随之取消。
+	// 其他节点转发来的请求只在本地处理，不再转发，即使两边的哈希环不一致也不会形成环路
+	var view ByteView
+	var err error
+	if hops > 0 {
+		view, err = group.getForPeer(r.Context(), key)
+	} else {
+		view, err = group.GetContext(r.Context(), key)
+	}
+	if errors.Is(err, ErrNotFound) {
+		w.Header().Set(notFoundHeader, "1")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -76,7 +297,7 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// ServeHTTP() 中使用 proto.Marshal() 编码 HTTP 响应
 	// Write the value to the response body as a proto message.
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	body, err := proto.Marshal(newResponse(view))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -89,66 +310,754 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(body)
 }
 
+// serveStats 以 JSON 格式返回统计信息，groupname 为空时返回所有 group
+func (p *HTTPPool) serveStats(w http.ResponseWriter, groupname string) {
+	var v interface{}
+	if groupname == "" {
+		all := make(map[string]Stats)
+		for name, g := range allGroups() {
+			all[name] = g.Stats()
+		}
+		v = all
+	} else {
+		group := GetGroup(groupname)
+		if group == nil {
+			http.Error(w, "no such group: "+groupname, http.StatusNotFound)
+			return
+		}
+		v = group.Stats()
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// serveBloom 返回 group 序列化后的布隆过滤器，没有开启或尚未构建时返回 404
+func (p *HTTPPool) serveBloom(w http.ResponseWriter, groupname string) {
+	group := GetGroup(groupname)
+	if group == nil {
+		http.Error(w, "no such group: "+groupname, http.StatusNotFound)
+		return
+	}
+	data, ok := group.BloomFilter()
+	if !ok {
+		http.Error(w, "bloom filter not available", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// serveBatch 处理其他节点发来的批量获取请求，请求体和响应都是 proto 编码的
+func (p *HTTPPool) serveBatch(w http.ResponseWriter, r *http.Request, groupname string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := GetGroup(groupname)
+	if group == nil {
+		http.Error(w, "no such group: "+groupname, http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &pb.BatchRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.checkRing(req.GetRing(), groupname, fmt.Sprintf("%d keys", len(req.GetKeys())))
+	body, err = proto.Marshal(group.batchResponse(r.Context(), req))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(body)
+}
+
+// PeersRequest 是节点管理接口 POST 和 DELETE 的请求体
+type PeersRequest struct {
+	Peers []string `json:"peers"`
+}
+
+// PeersResponse 是节点管理接口 POST 和 DELETE 的响应，Moved 是归属发生变化的 key 区间
+type PeersResponse struct {
+	Peers []string              `json:"peers"`
+	Moved []consistenthash.Move `json:"moved"`
+}
+
+// servePeers 处理节点管理接口。
+// GET 以 JSON 格式返回访问各个远程节点的统计信息；
+// POST 和 DELETE 的请求体为 PeersRequest，分别增加和删除节点，返回 PeersResponse，
+// 需要开启 WithPeerAdmin。只修改本节点的哈希环，运维需要对每个节点发起同样的请求。
+func (p *HTTPPool) servePeers(w http.ResponseWriter, r *http.Request) {
+	var v interface{}
+	switch r.Method {
+	case http.MethodGet:
+		v = p.PeerStats()
+	case http.MethodPost, http.MethodDelete:
+		if !p.peerAdmin {
+			http.Error(w, "peer admin is disabled", http.StatusForbidden)
+			return
+		}
+		var req PeersRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var moved []consistenthash.Move
+		if r.Method == http.MethodPost {
+			moved = p.AddPeers(req.Peers...)
+		} else {
+			moved = p.RemovePeers(req.Peers...)
+		}
+		v = PeersResponse{Peers: p.Peers(), Moved: moved}
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// PeerStats 是访问一个远程节点的统计信息
+type PeerStats struct {
+	Requests  int64        `json:"requests"` // 发起的请求，不含重试
+	Retries   int64        `json:"retries"`
+	Errors    int64        `json:"errors"`    // 重试之后仍然失败的请求
+	Failovers int64        `json:"failovers"` // 因为该节点不可用而改为访问下一个副本的请求
+	InFlight  int64        `json:"in_flight"` // 正在进行的请求
+	Migrated  int64        `json:"migrated"`  // 迁移时从该节点接收的记录
+	Breaker   BreakerStats `json:"breaker"`
+}
+
+// PeerStats 返回访问各个远程节点的统计信息，以节点地址为 key
+func (p *HTTPPool) PeerStats() map[string]PeerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make(map[string]PeerStats, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		st := p.peerStates[peer]
+		stats[peer] = PeerStats{
+			Requests:  st.requests.Get(),
+			Retries:   st.retries.Get(),
+			Errors:    st.errors.Get(),
+			Failovers: st.failovers.Get(),
+			InFlight:  st.inflight.Get(),
+			Migrated:  st.migrated.Get(),
+			Breaker:   st.breaker.stats(),
+		}
+	}
+	return stats
+}
+
+// serveSet 处理写入请求，请求体是 proto 编码的 pb.SetRequest。
+// 哈希环指纹已经在 ServeHTTP 中根据查询参数检查过，是否由其他节点转发以请求体中的 hops 为准。
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &pb.SetRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Key = key
+	if err = group.setFromPeer(req); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
 // Set updates the pool's list of peers
 // Set() 方法实例化了一致性哈希算法，并且添加了传入的节点
+// Set 会重建整个哈希环，运行中增删节点请使用 AddPeers 和 RemovePeers。
+// 节点可以写成 "peer=<地址>,weight=<权重>" 的形式，按权重分配 key，AddPeers 同样支持。
+// 开启了迁移时，本节点从原来的所有者拉取新分到的区间内的记录。
 func (p *HTTPPool) Set(peers ...string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...)
+	before := p.ranges()
+	p.peers = p.newPeerMap()
+	p.failovers = nil
 	p.httpGetters = make(map[string]*httpGetter, len(peers))
+	p.weights = make(map[string]int, len(peers))
 	for _, peer := range peers { // 并为每一个节点创建了一个 HTTP 客户端 httpGetter
-		p.httpGetters[peer] = &httpGetter{baseURL: peer + p.basePath}
+		addr, weight, err := parsePeer(peer)
+		if err != nil {
+			p.Log("ignoring peer: %v", err)
+			continue
+		}
+		p.addPeer(addr, weight)
+		p.httpGetters[addr] = p.newGetter(addr)
+	}
+	p.updateFingerprint()
+	if before != nil {
+		p.migrator.start(consistenthash.Moved(before, p.ranges()), p.dialMigration)
+	}
+}
+
+// newPeerMap 创建空的节点选择算法，默认是哈希环。开启有界负载时用正在进行的请求数作为节点的负载
+func (p *HTTPPool) newPeerMap() consistenthash.Placement {
+	if p.newPlacement != nil {
+		if p.loadEpsilon > 0 {
+			p.Log("bounded load is only supported by the hash ring, ignored")
+		}
+		return p.newPlacement()
+	}
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.SetBoundedLoads(p.loadEpsilon, p.peerLoad)
+	return ring
+}
+
+// addPeer 把节点加入 p.peers，算法不支持权重时忽略权重。调用方需持有 p.mu
+func (p *HTTPPool) addPeer(addr string, weight int) {
+	if p.weights == nil {
+		p.weights = make(map[string]int)
+	}
+	if w, ok := p.peers.(consistenthash.WeightedPlacement); ok {
+		w.AddWeighted(addr, weight)
+		p.weights[addr] = weight
+		return
+	}
+	if weight != 1 {
+		p.Log("placement %T does not support weights, ignoring weight of %s", p.peers, addr)
+	}
+	p.peers.Add(addr)
+	p.weights[addr] = 1
+}
+
+// updateFingerprint 在节点列表变化后重新计算哈希环的指纹。调用方需持有 p.mu
+func (p *HTTPPool) updateFingerprint() {
+	p.fingerprint = ringFingerprint(fmt.Sprintf("%T", p.peers), p.weights)
+}
+
+// RingFingerprint 返回哈希环的指纹，实现了 RingFingerprinter。
+// 节点列表、权重和选择节点的算法都相同的节点指纹相同，没有节点时为 0
+func (p *HTTPPool) RingFingerprint() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fingerprint
+}
+
+// checkRing 比较请求方的哈希环指纹，不一致时记录日志并计数。ring 为 0 表示请求方没有提供
+func (p *HTTPPool) checkRing(ring uint64, group, key string) {
+	if ring == 0 {
+		return
+	}
+	if local := p.RingFingerprint(); ring != local {
+		p.ringMismatches.Add(1)
+		p.Log("ring fingerprint mismatch on %s/%s: peer %016x, local %016x", group, key, ring, local)
+	}
+}
+
+// RingMismatches 返回远程节点发来的请求中哈希环指纹与本节点不一致的次数
+func (p *HTTPPool) RingMismatches() int64 {
+	return p.ringMismatches.Get()
+}
+
+// ranges 返回哈希环的区间，其他算法没有区间，返回 nil。调用方需持有 p.mu
+func (p *HTTPPool) ranges() []consistenthash.Range {
+	if ring, ok := p.peers.(*consistenthash.Map); ok {
+		return ring.Ranges()
+	}
+	return nil
+}
+
+// peerLoad 返回发往节点、尚未完成的请求数，本节点返回正在进行的本地加载数。只在持有 p.mu 时由哈希环调用
+func (p *HTTPPool) peerLoad(peer string) int64 {
+	if peer == p.self {
+		return p.localInflight.Get()
+	}
+	if st := p.peerStates[peer]; st != nil {
+		return st.inflight.Get()
+	}
+	return 0
+}
+
+// newGetter 为节点创建 httpGetter，统计和熔断器在节点被删除后仍然保留。调用方需持有 p.mu
+func (p *HTTPPool) newGetter(peer string) *httpGetter {
+	if p.peerStates == nil {
+		p.peerStates = make(map[string]*peerState)
+	}
+	if p.peerStates[peer] == nil {
+		p.peerStates[peer] = &peerState{
+			latency: newHistogram(defaultLatencyBuckets),
+			breaker: newBreaker(p.breakerThreshold, p.breakerCooldown),
+		}
+	}
+	return &httpGetter{
+		baseURL: peer + p.basePath,
+		client:  p.client,
+		timeout: p.timeout,
+		retries: p.retries,
+		backoff: p.backoff,
+		state:   p.peerStates[peer],
+	}
+}
+
+// AddPeers 把节点加入哈希环，不会重建整个环，已有节点的 httpGetter 保持不变。
+// 已经存在的节点只更新权重，权重相同时被忽略。返回归属发生变化的 key 区间，不使用哈希环时为 nil。
+// 开启了迁移时，本节点从原来的所有者拉取新分到的区间内的记录，RemovePeers 也一样。
+func (p *HTTPPool) AddPeers(peers ...string) []consistenthash.Move {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		p.peers = p.newPeerMap()
+		p.httpGetters = make(map[string]*httpGetter)
+	}
+	before := p.ranges()
+	var added, reweighted []string
+	for _, peer := range peers {
+		addr, weight, err := parsePeer(peer)
+		if err != nil {
+			p.Log("ignoring peer: %v", err)
+			continue
+		}
+		if _, ok := p.httpGetters[addr]; ok {
+			if p.weights[addr] == weight {
+				continue
+			}
+			if _, ok := p.peers.(consistenthash.WeightedPlacement); !ok {
+				p.Log("placement %T does not support weights, ignoring weight of %s", p.peers, addr)
+				continue
+			}
+			p.addPeer(addr, weight)
+			reweighted = append(reweighted, addr)
+			continue
+		}
+		p.httpGetters[addr] = p.newGetter(addr)
+		p.addPeer(addr, weight)
+		added = append(added, addr)
+	}
+	if len(added) == 0 && len(reweighted) == 0 {
+		return nil
+	}
+	p.failovers = nil
+	p.updateFingerprint()
+	moved := consistenthash.Moved(before, p.ranges())
+	p.Log("added peers %v, reweighted peers %v, %d key ranges moved", added, reweighted, len(moved))
+	p.migrator.start(moved, p.dialMigration)
+	return moved
+}
+
+// RemovePeers 把节点移出哈希环，其余节点的 httpGetter 保持不变。
+// 不存在的节点会被忽略。返回归属发生变化的 key 区间，不使用哈希环时为 nil。
+func (p *HTTPPool) RemovePeers(peers ...string) []consistenthash.Move {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil
+	}
+	before := p.ranges()
+	var removed []string
+	for _, peer := range peers {
+		addr, _, err := parsePeer(peer)
+		if err != nil {
+			p.Log("ignoring peer: %v", err)
+			continue
+		}
+		if _, ok := p.httpGetters[addr]; !ok {
+			continue
+		}
+		delete(p.httpGetters, addr)
+		delete(p.weights, addr)
+		p.peers.Remove(addr)
+		removed = append(removed, addr)
 	}
+	if len(removed) == 0 {
+		return nil
+	}
+	p.failovers = nil
+	p.updateFingerprint()
+	moved := consistenthash.Moved(before, p.ranges())
+	p.Log("removed peers %v, %d key ranges moved", removed, len(moved))
+	p.migrator.start(moved, p.dialMigration)
+	return moved
+}
+
+// Peers 返回当前所有节点（包括自己），按地址排序
+func (p *HTTPPool) Peers() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	peers := make([]string, 0, len(p.httpGetters))
+	for peer := range p.httpGetters {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	return peers
 }
 
 // PickPeer picks a peer according to key
-// PickPeer() 包装了一致性哈希算法的 Get() 方法，根据具体的 key，
+// PickPeer() 包装了一致性哈希算法的 GetN() 方法，根据具体的 key，
 // 选择节点，返回节点对应的 HTTP 客户端。
+// 开启了副本时，返回的客户端在前一个副本不可用时依次访问后面的副本。
 func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if peer := p.peers.Get(key); peer != "" && peer != p.self {
-		p.Log("pick peer %s", peer)
-		return p.httpGetters[peer], true
+	if p.peers == nil {
+		return nil, false
+	}
+	if getter, ok := p.migrator.route(p.peers, key); ok { // 迁移完成前，key 仍然由原来的所有者处理
+		return getter, getter != nil
+	}
+	var candidates []string
+	for _, peer := range p.peers.GetN(key, p.replication) {
+		if peer == p.self { // 之后的副本不再需要，前面的节点都不可用时由本地加载
+			break
+		}
+		if !p.peerStates[peer].breaker.ready() { // 熔断期间不再路由到该节点
+			p.Log("peer %s circuit open, skipped", peer)
+			continue
+		}
+		candidates = append(candidates, peer)
+	}
+	switch len(candidates) {
+	case 0:
+		return nil, false
+	case 1:
+		p.Log("pick peer %s", candidates[0])
+		return p.httpGetters[candidates[0]], true
+	}
+	p.Log("pick peers %v", candidates)
+	id := strings.Join(candidates, " ")
+	if f, ok := p.failovers[id]; ok { // 复用同一个对象，GetMulti 才能把这些 key 合并成一个批量请求
+		return f, true
+	}
+	f := &failoverGetter{getters: make([]*httpGetter, len(candidates))}
+	for i, peer := range candidates {
+		f.getters[i] = p.httpGetters[peer]
+	}
+	if p.failovers == nil {
+		p.failovers = make(map[string]*failoverGetter)
+	}
+	p.failovers[id] = f
+	return f, true
+}
+
+// GetAll 返回除自己以外所有节点的 HTTP 客户端
+func (p *HTTPPool) GetAll() []PeerGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var peers []PeerGetter
+	for peer, getter := range p.httpGetters {
+		if peer != p.self {
+			peers = append(peers, getter)
+		}
 	}
-	return nil, false
+	return peers
 }
 
 var _ PeerPicker = (*HTTPPool)(nil) // 确保这个类型实现了这个接口 如果没有实现会报错的
+var _ RingFingerprinter = (*HTTPPool)(nil)
+var _ localLoadTracker = (*HTTPPool)(nil)
+
+// trackLocalLoads 记录 n 个正在进行的本地加载，实现了 localLoadTracker
+func (p *HTTPPool) trackLocalLoads(n int) func() {
+	p.localInflight.Add(int64(n))
+	return func() { p.localInflight.Add(-int64(n)) }
+}
+
+// failoverGetter 依次访问一个 key 的各个副本节点，前一个节点不可用时才访问下一个
+type failoverGetter struct {
+	getters []*httpGetter
+}
+
+// peerAddr 返回第一个副本的地址，实现了 peerAddresser
+func (f *failoverGetter) peerAddr() string {
+	return f.getters[0].peerAddr()
+}
+
+func (f *failoverGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return f.try(ctx, func(h *httpGetter) error { return h.Get(ctx, in, out) })
+}
+
+func (f *failoverGetter) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	return f.try(ctx, func(h *httpGetter) error { return h.Set(ctx, in, out) })
+}
+
+func (f *failoverGetter) Remove(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return f.try(ctx, func(h *httpGetter) error { return h.Remove(ctx, in, out) })
+}
+
+func (f *failoverGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	return f.try(ctx, func(h *httpGetter) error { return h.GetMulti(ctx, in, out) })
+}
+
+// try 按顺序对副本调用 fn，直到成功或者失败的原因不是节点不可用。
+// 节点正常返回的错误（例如 ErrNotFound）不会转移到下一个副本。
+func (f *failoverGetter) try(ctx context.Context, fn func(h *httpGetter) error) error {
+	var err error
+	for i, h := range f.getters {
+		if err = fn(h); err == nil || ctx.Err() != nil || !isUnavailable(err) {
+			return err
+		}
+		if i+1 < len(f.getters) {
+			if h.state != nil {
+				h.state.failovers.Add(1)
+			}
+			log.Printf("[GeeCache] peer %s unavailable, failing over to %s: %v", h.baseURL, f.getters[i+1].baseURL, err)
+		}
+	}
+	return err
+}
+
+// isUnavailable 判断错误是否表示节点不可用，可以换一个副本重试
+func isUnavailable(err error) bool {
+	return isPeerFailure(err) || errors.Is(err, errBreakerOpen)
+}
 
 // 首先创建具体的 HTTP 客户端类 httpGetter，实现 PeerGetter 接口。
+// 零值也可以使用：没有超时、不重试、不熔断。
 type httpGetter struct {
 	baseURL string
+	client  *http.Client  // 为 nil 时使用 http.DefaultClient
+	timeout time.Duration // 每次请求的超时时间
+	retries int
+	backoff time.Duration
+	state   *peerState // 统计和熔断器，可以为 nil
+}
+
+// peerAddr 返回节点的 baseURL，实现了 peerAddresser
+func (h *httpGetter) peerAddr() string {
+	return h.baseURL
+}
+
+func (h *httpGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return h.do(ctx, http.MethodGet, h.keyURL(in.GetGroup(), in.GetKey())+peerQuery(in.GetRing(), in.GetHops()), nil, out)
+}
+
+// Set 使用 PUT 请求把 proto 编码的 pb.SetRequest 发给远程节点
+func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(ctx, http.MethodPut, h.keyURL(in.GetGroup(), in.GetKey())+peerQuery(in.GetRing(), in.GetHops()), body, out)
+}
+
+// Remove 使用 DELETE 请求删除远程节点上的 key
+func (h *httpGetter) Remove(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return h.do(ctx, http.MethodDelete, h.keyURL(in.GetGroup(), in.GetKey())+peerQuery(in.GetRing(), in.GetHops()), nil, out)
+}
+
+// GetMulti 使用 POST 请求把 proto 编码的 pb.BatchRequest 发给远程节点，实现了 BatchPeerGetter
+func (h *httpGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(ctx, http.MethodPost, h.baseURL+batchPath+"/"+url.QueryEscape(in.GetGroup()), body, out)
+}
+
+// GetBloomFilter 获取远程节点上 group 序列化后的布隆过滤器，实现了 BloomFetcher
+func (h *httpGetter) GetBloomFilter(ctx context.Context, group string) ([]byte, error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	u := h.baseURL + bloomPath + "/" + url.QueryEscape(group)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned: %v", res.Status)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %v", err)
+	}
+	return data, nil
 }
 
-func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	u := fmt.Sprintf(
+// keyURL 返回 group 中 key 的地址
+func (h *httpGetter) keyURL(group, key string) string {
+	return fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL, // baseURL 表示将要访问的远程节点的地址，例如 http://example.com/_geecache/
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		url.QueryEscape(group),
+		url.QueryEscape(key),
 	)
-	res, err := http.Get(u) // 使用 http.Get() 方式获取返回值，并转换为 []bytes 类型
+}
+
+// peerQuery 把请求的哈希环指纹和经过的节点数编码成查询参数，都为 0 时返回空字符串
+func peerQuery(ring uint64, hops uint32) string {
+	if ring == 0 && hops == 0 {
+		return ""
+	}
+	return fmt.Sprintf("?ring=%x&hops=%d", ring, hops)
+}
+
+// parsePeerQuery 解析 peerQuery 编码的查询参数，无法解析的值视为 0
+func parsePeerQuery(q url.Values) (ring uint64, hops uint32) {
+	ring, _ = strconv.ParseUint(q.Get("ring"), 16, 64)
+	n, _ := strconv.ParseUint(q.Get("hops"), 10, 32)
+	return ring, uint32(n)
+}
+
+// do 向远程节点的地址 u 发起请求，并将响应解码到 out 中。
+// 网络错误、超时和 502/503/504 会按退避时间重试，重试之后仍然失败才计入熔断器。
+func (h *httpGetter) do(ctx context.Context, method, u string, body []byte, out proto.Message) error {
+	st := h.state
+	if st == nil {
+		st = &peerState{}
+	}
+	if !st.breaker.allow() {
+		return errBreakerOpen
+	}
+	st.requests.Add(1)
+	st.inflight.Add(1)
+	defer st.inflight.Add(-1)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		if retry, err = h.try(ctx, st, method, u, body, out); err == nil || !retry || attempt >= h.retries {
+			break
+		}
+		st.retries.Add(1)
+		if !sleepContext(ctx, backoffDuration(h.backoff, attempt)) {
+			break
+		}
+	}
+
+	switch {
+	case err == nil, errors.Is(err, ErrNotFound): // key 不存在是正常的结果
+		st.breaker.success()
+	case ctx.Err() != nil: // 调用方放弃了请求，不代表远程节点有问题
+		st.errors.Add(1)
+		st.breaker.cancel()
+	case isPeerFailure(err):
+		st.errors.Add(1)
+		st.breaker.failure()
+	default: // 远程节点正常返回了错误，例如数据源加载失败
+		st.errors.Add(1)
+		st.breaker.success()
+	}
+	return err
+}
+
+// peerFailure 表示远程节点不可用：网络错误、超时或网关类错误，可以重试
+type peerFailure struct {
+	err error
+}
+
+func (e *peerFailure) Error() string { return e.err.Error() }
+
+func (e *peerFailure) Unwrap() error { return e.err }
+
+func isPeerFailure(err error) bool {
+	var pf *peerFailure
+	return errors.As(err, &pf)
+}
+
+// try 发起一次请求，retry 表示失败是否值得重试
+func (h *httpGetter) try(ctx context.Context, st *peerState, method, u string, body []byte, out proto.Message) (retry bool, err error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return false, err
+	}
+	if st.latency != nil {
+		defer func(start time.Time) { st.latency.observe(time.Since(start)) }(time.Now())
+	}
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req) // 发起请求获取返回值，并转换为 []bytes 类型
 	if err != nil {
-		return err
+		return true, &peerFailure{err}
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return true, &peerFailure{fmt.Errorf("reading response body: %v", err)}
 	}
 
-	bytes, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return fmt.Errorf("reading response body: %v", err)
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		if res.Header.Get(notFoundHeader) != "" {
+			return false, ErrNotFound
+		}
+		return false, fmt.Errorf("server returned: %v", res.Status)
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true, &peerFailure{fmt.Errorf("server returned: %v", res.Status)}
+	default:
+		return false, fmt.Errorf("server returned: %v", res.Status)
 	}
 
-	if err = proto.Unmarshal(bytes, out); err != nil {
-		return fmt.Errorf("decoding response body: %v", err)
+	if err = proto.Unmarshal(data, out); err != nil {
+		return false, fmt.Errorf("decoding response body: %v", err)
 	}
 
-	return nil
+	return false, nil
+}
+
+// backoffDuration 返回第 attempt 次重试前的等待时间：base * 2^attempt，再乘以 [0.5, 1.5) 的随机抖动
+func backoffDuration(base time.Duration, attempt int) time.Duration {
+	d := base << uint(attempt)
+	return time.Duration(float64(d) * (0.5 + rand.Float64()))
+}
+
+// sleepContext 等待 d，ctx 先结束时返回 false
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
-var _ PeerGetter = (*httpGetter)(nil)
+var (
+	_ PeerGetter      = (*httpGetter)(nil)
+	_ BloomFetcher    = (*httpGetter)(nil)
+	_ BatchPeerGetter = (*httpGetter)(nil)
+	_ PeerGetter      = (*failoverGetter)(nil)
+	_ BatchPeerGetter = (*failoverGetter)(nil)
+	_ peerAddresser   = (*httpGetter)(nil)
+	_ peerAddresser   = (*failoverGetter)(nil)
+)
@@ -0,0 +1,172 @@
+package geecache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	pb "geecache/geecachepb"
+)
+
+// 主节点不可用时依次访问后面的副本
+func TestHTTPPoolFailover(t *testing.T) {
+	gee := NewGroup("failover-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("v" + key), nil
+		}))
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+	live := httptest.NewServer(NewHTTPPool(""))
+	defer live.Close()
+
+	pool := NewHTTPPool("self", WithRetries(0, 0), WithCircuitBreaker(0, 0), WithReplication(2))
+	pool.Set(dead.URL, live.URL)
+	// 找到主节点是 dead 的 key
+	var keys []string
+	for i := 0; len(keys) < 2; i++ {
+		if key := strconv.Itoa(i); pool.peers.Get(key) == dead.URL {
+			keys = append(keys, key)
+		}
+	}
+
+	getter, ok := pool.PickPeer(keys[0])
+	if !ok {
+		t.Fatal("failed to pick peer")
+	}
+	if other, _ := pool.PickPeer(keys[1]); other != getter {
+		t.Fatal("keys with the same replicas should share a getter")
+	}
+	out := &pb.Response{}
+	if err := getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: keys[0]}, out); err != nil || string(out.GetValue()) != "v"+keys[0] {
+		t.Fatalf("expected failover to the live replica, got %q (%v)", out.GetValue(), err)
+	}
+	if stats := pool.PeerStats(); stats[dead.URL].Failovers != 1 || stats[live.URL].Failovers != 0 {
+		t.Fatalf("unexpected peer stats %+v", stats)
+	}
+
+	// 本节点是第二个副本时，主节点不可用后由本地加载
+	local := NewHTTPPool(live.URL, WithReplication(2))
+	local.Set(dead.URL, live.URL)
+	getter, ok = local.PickPeer(keys[0])
+	if _, isFailover := getter.(*failoverGetter); !ok || isFailover {
+		t.Fatalf("expected only the primary when self is the next replica, got %T", getter)
+	}
+}
+
+// 有界负载模式下，正在进行的请求过多的节点不再被选中
+func TestHTTPPoolBoundedLoad(t *testing.T) {
+	gee := NewGroup("bounded-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte("v" + key), nil
+		}))
+	release := make(chan struct{})
+	peerPool := NewHTTPPool("")
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		peerPool.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(peerPool)
+	defer fast.Close()
+
+	pool := NewHTTPPool("self", WithBoundedLoad(0.1))
+	pool.Set(slow.URL, fast.URL)
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := strconv.Itoa(i); pool.peers.Get(k) == slow.URL {
+			key = k
+		}
+	}
+	getter, _ := pool.PickPeer(key)
+	if getter != pool.httpGetters[slow.URL] {
+		t.Fatal("idle slow peer should own the key")
+	}
+
+	// 两个请求阻塞在 slow 上：总负载 2，上限 ceil(1.1 * 3 / 2) = 2，slow 再加一个请求就超过上限
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: key}, &pb.Response{})
+		}()
+	}
+	waitFor(t, func() bool { return pool.PeerStats()[slow.URL].InFlight == 2 })
+	if other, _ := pool.PickPeer(key); other != pool.httpGetters[fast.URL] {
+		t.Fatal("overloaded peer should be skipped")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if getter, _ = pool.PickPeer(key); getter != pool.httpGetters[slow.URL] || pool.PeerStats()[slow.URL].InFlight != 0 {
+		t.Fatal("key should return to its owner once the load drops")
+	}
+}
+
+// 有界负载模式下本节点的负载是正在进行的本地加载，本节点过载时 key 交给下一个节点
+func TestHTTPPoolBoundedLoadSelf(t *testing.T) {
+	pool := NewHTTPPool("http://self", WithBoundedLoad(0.1))
+	pool.Set("http://self", "http://other")
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := strconv.Itoa(i); pool.peers.Get(k) == "http://self" {
+			key = k
+		}
+	}
+	if _, remote := pool.PickPeer(key); remote {
+		t.Fatal("idle self should own the key")
+	}
+
+	// 两个本地加载：总负载 2，上限 ceil(1.1 * 3 / 2) = 2，本节点再加一个就超过上限
+	done := pool.trackLocalLoads(2)
+	if getter, remote := pool.PickPeer(key); !remote || getter != pool.httpGetters["http://other"] {
+		t.Fatal("overloaded self should hand the key to the next peer")
+	}
+	done()
+	if _, remote := pool.PickPeer(key); remote {
+		t.Fatal("key should return to self once local loads finish")
+	}
+}
+
+// 客户端直接发来的 DELETE 在整个集群中删除，其他节点发来的通知只删除本节点的副本
+func TestHTTPRemoveBroadcast(t *testing.T) {
+	gee := NewGroup("http-remove-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	var deletes AtomicInt
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deletes.Add(1)
+		}
+	}))
+	defer other.Close()
+	pool := NewHTTPPool("http://self")
+	pool.Set("http://self", other.URL)
+	gee.RegisterPeers(pool)
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	getter := &httpGetter{baseURL: server.URL + defaultBasePath}
+	gee.hotCache.add("Tom", ByteView{b: []byte("630")})
+	if err := getter.Remove(context.Background(), &pb.Request{Group: gee.name, Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.hotCache.get("Tom"); ok || deletes.Get() != 1 {
+		t.Fatalf("expected Tom removed here and on the other node, %d deletes sent", deletes.Get())
+	}
+
+	gee.hotCache.add("Tom", ByteView{b: []byte("630")})
+	if err := getter.Remove(context.Background(), &pb.Request{Group: gee.name, Key: "Tom", Hops: 1}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gee.hotCache.get("Tom"); ok || deletes.Get() != 1 {
+		t.Fatalf("a peer's notice should only remove the local copy, %d deletes sent", deletes.Get())
+	}
+}
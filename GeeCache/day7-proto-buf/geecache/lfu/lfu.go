@@ -0,0 +1,183 @@
+package lfu
+
+import (
+	"container/heap"
+	"geecache/lru"
+	"time"
+)
+
+// Value 与 lru.Value 相同，使用 Len 来计算它需要多少字节
+type Value = lru.Value
+
+// EvictReason 与 lru.EvictReason 相同，表示记录被移除的原因
+type EvictReason = lru.EvictReason
+
+// Cache is a LFU cache. It is not safe for concurrent access.
+// 最少使用：淘汰访问次数最少的记录，访问次数相同时淘汰最久没有访问的。
+type Cache struct {
+	maxBytes int64             // 允许使用的最大内存
+	nbytes   int64             // 当前已使用的内存
+	pq       priorityQueue     // 按访问次数排序的小顶堆，堆顶是下一个被淘汰的记录
+	cache    map[string]*entry // 键是字符串，值是堆中对应的记录
+	tick     uint64            // 逻辑时钟，记录每次访问的先后
+	// 可选并在清除entry时执行。
+	OnEvicted func(key string, value Value)
+	// 可选，与 OnEvicted 相同，但额外带上移除原因
+	OnEvictedWithReason func(key string, value Value, reason EvictReason)
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
+	freq   int       // 访问次数
+	last   uint64    // 最近一次访问的逻辑时间
+	index  int       // 在堆中的下标，由 heap.Interface 维护
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// priorityQueue 实现了 heap.Interface
+type priorityQueue []*entry
+
+func (pq priorityQueue) Len() int { return len(pq) }
+
+func (pq priorityQueue) Less(i, j int) bool {
+	if pq[i].freq == pq[j].freq {
+		return pq[i].last < pq[j].last
+	}
+	return pq[i].freq < pq[j].freq
+}
+
+func (pq priorityQueue) Swap(i, j int) {
+	pq[i], pq[j] = pq[j], pq[i]
+	pq[i].index = i
+	pq[j].index = j
+}
+
+func (pq *priorityQueue) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*pq)
+	*pq = append(*pq, e)
+}
+
+func (pq *priorityQueue) Pop() interface{} {
+	old := *pq
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*pq = old[:n-1]
+	return e
+}
+
+// New 是 Cache 的构造函数
+func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	return &Cache{
+		maxBytes:  maxBytes,
+		cache:     make(map[string]*entry),
+		OnEvicted: onEvicted,
+	}
+}
+
+// touch 记录一次访问：访问次数加 1，并调整它在堆中的位置
+func (c *Cache) touch(e *entry) {
+	c.tick++
+	e.freq++
+	e.last = c.tick
+	heap.Fix(&c.pq, e.index)
+}
+
+// Get 查找键的值，并增加它的访问次数
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	if e, ok := c.cache[key]; ok {
+		if e.expired(time.Now()) {
+			c.removeEntry(e, lru.EvictExpired)
+			return nil, false
+		}
+		c.touch(e)
+		return e.value, true
+	}
+	return
+}
+
+// RemoveLeastFrequent 淘汰访问次数最少的记录
+func (c *Cache) RemoveLeastFrequent() {
+	if c.pq.Len() > 0 {
+		c.removeEntry(c.pq[0], lru.EvictCapacity)
+	}
+}
+
+// Remove 从缓存中删除 key 对应的记录
+func (c *Cache) Remove(key string) {
+	if e, ok := c.cache[key]; ok {
+		c.removeEntry(e, lru.EvictRemoved)
+	}
+}
+
+// RemoveExpired 移除所有已过期的记录，返回移除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	var expired []*entry
+	for _, e := range c.pq {
+		if e.expired(now) {
+			expired = append(expired, e)
+		}
+	}
+	for _, e := range expired {
+		c.removeEntry(e, lru.EvictExpired)
+	}
+	return len(expired)
+}
+
+func (c *Cache) removeEntry(e *entry, reason EvictReason) {
+	heap.Remove(&c.pq, e.index)
+	delete(c.cache, e.key)
+	c.nbytes -= int64(len(e.key)) + int64(e.value.Len())
+	if c.OnEvicted != nil {
+		c.OnEvicted(e.key, e.value)
+	}
+	if c.OnEvictedWithReason != nil {
+		c.OnEvictedWithReason(e.key, e.value, reason)
+	}
+}
+
+// Add 向缓存中添加一个值，该值永不过期
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL 向缓存中添加一个值，ttl 之后该值过期；ttl <= 0 表示永不过期。
+// 添加和更新都算作一次访问。
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	if e, ok := c.cache[key]; ok {
+		c.nbytes += int64(value.Len()) - int64(e.value.Len())
+		e.value = value
+		e.expire = expire
+		c.touch(e)
+	} else {
+		e := &entry{key: key, value: value, expire: expire}
+		heap.Push(&c.pq, e)
+		c.cache[key] = e
+		c.nbytes += int64(len(key)) + int64(value.Len())
+		c.touch(e)
+	}
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveLeastFrequent()
+	}
+}
+
+// Len 用来获取添加了多少条数据
+func (c *Cache) Len() int {
+	return c.pq.Len()
+}
+
+// Bytes 返回当前已使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
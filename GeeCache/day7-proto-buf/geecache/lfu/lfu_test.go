@@ -0,0 +1,56 @@
+package lfu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// 测试淘汰访问次数最少的记录，次数相同时淘汰最久没有访问的
+func TestRemoveLeastFrequent(t *testing.T) {
+	keys := make([]string, 0)
+	lfu := New(int64(len("k1v1k2v2k3v3")), func(key string, value Value) {
+		keys = append(keys, key)
+	})
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Add("k3", String("v3"))
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+	lfu.Add("k4", String("v4")) // k2 只访问过 1 次
+	lfu.Add("k5", String("v5")) // k4 与 k3 都访问过 1 次以上，k4 只有 1 次
+
+	expect := []string{"k2", "k4"}
+	if !reflect.DeepEqual(expect, keys) || lfu.Len() != 3 {
+		t.Fatalf("expected evicted keys %v, got %v", expect, keys)
+	}
+	if _, ok := lfu.Get("k1"); !ok {
+		t.Fatalf("frequently used k1 should stay in cache")
+	}
+}
+
+func TestAddWithTTL(t *testing.T) {
+	lfu := New(int64(0), nil)
+	lfu.AddWithTTL("k1", String("v1"), 10*time.Millisecond)
+	lfu.AddWithTTL("k2", String("v2"), 10*time.Millisecond)
+	lfu.Add("k3", String("v3"))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := lfu.Get("k1"); ok {
+		t.Fatalf("k1 should be expired")
+	}
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 1 || lfu.Bytes() != int64(len("k3v3")) {
+		t.Fatalf("expected only k3 left, removed %d", n)
+	}
+	lfu.Remove("k3")
+	if lfu.Len() != 0 || lfu.Bytes() != 0 {
+		t.Fatalf("Remove k3 failed")
+	}
+}
@@ -1,21 +1,56 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
 
 // Cache is a LRU cache. It is not safe for concurrent access.
-type Cache struct {
+// 键的类型为 K，值的类型为 V，每条记录占用的内存由 size 函数计算。
+type Cache[K comparable, V any] struct {
 	maxBytes int64 // 允许使用的最大内存
 	nbytes int64 // 当前已使用的内存
 	ll *list.List // Go 语言标准库实现的双向链表list.List
-	cache map[string]*list.Element // 键是字符串，值是双向链表中对应节点的指针
+	cache map[K]*list.Element // 值是双向链表中对应节点的指针
+	size func(key K, value V) int64 // 计算一条记录占用的内存
 	// 可选并在清除entry时执行。
-	OnEvicted func(key string, value Value) // 某条记录被移除时的回调函数，可以为 nil
+	OnEvicted func(key K, value V) // 某条记录被移除时的回调函数，可以为 nil
+	// 可选，与 OnEvicted 相同，但额外带上移除原因，用来区分过期淘汰和容量淘汰
+	OnEvictedWithReason func(key K, value V, reason EvictReason)
 }
 
 // 记录的定义
-type entry struct {
-	key string
-	value Value
+type entry[K comparable, V any] struct {
+	key K
+	value V
+	expire time.Time // 过期时间，零值表示永不过期
+}
+
+// expired 判断记录在 now 时刻是否已经过期
+func (e *entry[K, V]) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// EvictReason 表示记录被移除的原因
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota // 超出 maxBytes 被淘汰
+	EvictExpired                     // 超过 TTL 过期被移除
+	EvictRemoved                     // 调用 Remove 主动删除
+)
+
+// String 返回移除原因的可读名称
+func (r EvictReason) String() string {
+	switch r {
+	case EvictCapacity:
+		return "capacity"
+	case EvictExpired:
+		return "expired"
+	case EvictRemoved:
+		return "removed"
+	}
+	return "unknown"
 }
 
 // Value 使用使用 Len 来计算它需要多少字节
@@ -23,51 +58,112 @@ type Value interface { // 实现了 Value 接口的任意类型
 	Len() int // 用于返回值所占用的内存大小
 }
 
-// New 是 Cache 的构造函数
-func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
-	return &Cache{
+// NewCache 是 Cache 的构造函数，maxBytes 为 0 表示不限制。
+// size 计算一条记录占用的内存，为 nil 时每条记录算作 1，此时 maxBytes 即最大条数。
+func NewCache[K comparable, V any](maxBytes int64, size func(key K, value V) int64) *Cache[K, V] {
+	if size == nil {
+		size = func(K, V) int64 { return 1 }
+	}
+	return &Cache[K, V]{
 		maxBytes: maxBytes,
 		ll: list.New(),
-		cache: make(map[string]*list.Element),
-		OnEvicted: onEvicted,
+		cache: make(map[K]*list.Element),
+		size: size,
 	}
 }
 
-// Get 查找键的值
-func (c *Cache) Get(key string) (value Value, ok bool) {
+// New 创建键为 string、值为 Value 的 Cache，记录占用的内存为 len(key) + value.Len()。
+// 保留它是为了兼容泛型之前的调用方。
+func New(maxBytes int64, onEvicted func(string, Value)) *Cache[string, Value] {
+	c := NewCache[string, Value](maxBytes, valueSize)
+	c.OnEvicted = onEvicted
+	return c
+}
+
+func valueSize(key string, value Value) int64 {
+	return int64(len(key)) + int64(value.Len())
+}
+
+// Get 查找键的值，已过期的记录会被惰性删除并视为未命中
+func (c *Cache[K, V]) Get(key K) (value V, ok bool) {
 	if ele, ok := c.cache[key]; ok {
+		if ele.Value.(*entry[K, V]).expired(time.Now()) {
+			c.removeElement(ele, EvictExpired)
+			return value, false
+		}
 		c.ll.MoveToFront(ele) // 将链表中的节点 ele 移动到队尾（双向链表作为队列，队首队尾是相对的，在这里约定 front 为队尾）
-		kv := ele.Value.(*entry)
+		kv := ele.Value.(*entry[K, V])
 		return kv.value, true
 	}
 	return
 }
 
 // 删除，RemoveOldest实际上是缓存淘汰. 移除最近最少访问的节点（队首）。
-func (c *Cache) RemoveOldest() {
+func (c *Cache[K, V]) RemoveOldest() {
 	ele := c.ll.Back() // 返回链表最后一个元素(取到队首节点)
 	if ele != nil {
-		c.ll.Remove(ele) // 删除链表中的元素ele
-		kv := ele.Value.(*entry)
-		delete(c.cache, kv.key) // 从字典中 c.cache 删除该节点的映射关系
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len()) // 更新当前所用的内存 c.nbytes
-		if c.OnEvicted != nil { // 如果回调函数 OnEvicted 不为 nil，则调用回调函数
-			c.OnEvicted(kv.key, kv.value)
+		c.removeElement(ele, EvictCapacity)
+	}
+}
+
+// Remove 从缓存中删除 key 对应的记录
+func (c *Cache[K, V]) Remove(key K) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictRemoved)
+	}
+}
+
+// RemoveExpired 移除所有已过期的记录，返回移除的条数。
+// Get 只会惰性删除被访问到的过期记录，其余的需要定期调用 RemoveExpired 回收。
+func (c *Cache[K, V]) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev() // 删除前先记下前一个节点
+		if ele.Value.(*entry[K, V]).expired(now) {
+			c.removeElement(ele, EvictExpired)
+			n++
 		}
+		ele = prev
+	}
+	return n
+}
+
+// removeElement 从链表和字典中删除节点，并触发回调
+func (c *Cache[K, V]) removeElement(ele *list.Element, reason EvictReason) {
+	c.ll.Remove(ele) // 删除链表中的元素ele
+	kv := ele.Value.(*entry[K, V])
+	delete(c.cache, kv.key) // 从字典中 c.cache 删除该节点的映射关系
+	c.nbytes -= c.size(kv.key, kv.value) // 更新当前所用的内存 c.nbytes
+	if c.OnEvicted != nil { // 如果回调函数 OnEvicted 不为 nil，则调用回调函数
+		c.OnEvicted(kv.key, kv.value)
+	}
+	if c.OnEvictedWithReason != nil {
+		c.OnEvictedWithReason(kv.key, kv.value, reason)
 	}
 }
 
-// 新增/修改: Add 向缓存中添加一个值。
-func (c *Cache) Add(key string, value Value) {
+// 新增/修改: Add 向缓存中添加一个值，该值永不过期。
+func (c *Cache[K, V]) Add(key K, value V) {
+	c.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL 向缓存中添加一个值，ttl 之后该值过期；ttl <= 0 表示永不过期。
+func (c *Cache[K, V]) AddWithTTL(key K, value V, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
 	if ele, ok := c.cache[key]; ok { // 如果键存在，则更新对应节点的值，并将该节点移到队尾
 		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len()) // 新增的值大小减去原有key对应值的大小
+		kv := ele.Value.(*entry[K, V])
+		c.nbytes += c.size(key, value) - c.size(key, kv.value) // 新增的值大小减去原有key对应值的大小
 		kv.value = value
+		kv.expire = expire
 	} else { // 不存在则是新增场景，首先队尾添加新节点 &entry{key, value}, 并字典中添加 key 和节点的映射关系。
-		ele := c.ll.PushFront(&entry{key, value}) // 将一个值为v的新元素插入链表的第一个位置(队尾)，返回生成的新元素
+		ele := c.ll.PushFront(&entry[K, V]{key, value, expire}) // 将一个值为v的新元素插入链表的第一个位置(队尾)，返回生成的新元素
 		c.cache[key] = ele
-		c.nbytes += int64(len(key)) + int64(value.Len()) // 更新 c.nbytes
+		c.nbytes += c.size(key, value) // 更新 c.nbytes
 	}
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
 		c.RemoveOldest() // 如果当前内存超过了设定的最大值 c.maxBytes，则移除最少访问的节点。
@@ -75,7 +171,68 @@ func (c *Cache) Add(key string, value Value) {
 }
 
 //  Len() 用来获取添加了多少条数据
-func (c *Cache) Len() int {
+func (c *Cache[K, V]) Len() int {
 	return c.ll.Len()
 }
 
+// Bytes 返回当前已使用的内存
+func (c *Cache[K, V]) Bytes() int64 {
+	return c.nbytes
+}
+
+// Peek 查找键的值，但不会把记录移到队尾，也不会删除已过期的记录（视为未命中）
+func (c *Cache[K, V]) Peek(key K) (value V, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry[K, V])
+		if !kv.expired(time.Now()) {
+			return kv.value, true
+		}
+	}
+	return
+}
+
+// Contains 判断键是否在缓存中且没有过期，不改变访问顺序
+func (c *Cache[K, V]) Contains(key K) bool {
+	_, ok := c.Peek(key)
+	return ok
+}
+
+// Keys 按从最近访问到最久未访问的顺序返回所有未过期的键
+func (c *Cache[K, V]) Keys() []K {
+	keys := make([]K, 0, c.ll.Len())
+	c.Range(func(key K, value V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Range 按从最近访问到最久未访问的顺序遍历未过期的记录，f 返回 false 时停止。
+// 遍历过程中不能修改缓存。
+func (c *Cache[K, V]) Range(f func(key K, value V) bool) {
+	now := time.Now()
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		kv := ele.Value.(*entry[K, V])
+		if kv.expired(now) {
+			continue
+		}
+		if !f(kv.key, kv.value) {
+			return
+		}
+	}
+}
+
+// Clear 从最久未访问的记录开始删除所有记录，每条记录都会触发回调
+func (c *Cache[K, V]) Clear() {
+	for ele := c.ll.Back(); ele != nil; ele = c.ll.Back() {
+		c.removeElement(ele, EvictRemoved)
+	}
+}
+
+// SetMaxBytes 修改内存上限，并淘汰最久未访问的记录直到不超过新的上限。0 表示不限制。
+func (c *Cache[K, V]) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
+		c.RemoveOldest()
+	}
+}
@@ -3,6 +3,7 @@ package lru
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -65,6 +66,155 @@ func TestAdd(t *testing.T) {
 	lru.Add("key", String("111"))
 
 	if lru.nbytes != int64(len("key") + len("111")) {
-		t.Fatalf("expected 6 but got", lru.nbytes)
+		t.Fatalf("expected 6 but got %d", lru.nbytes)
 	}
-}
\ No newline at end of file
+}
+
+// 测试AddWithTTL方法，过期的记录在 Get 时被惰性删除
+func TestAddWithTTL(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(0), nil)
+	lru.OnEvictedWithReason = func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	}
+	lru.AddWithTTL("key1", String("1234"), 10*time.Millisecond)
+	lru.Add("key2", String("5678"))
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("cache hit key1 before expire failed")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 1 {
+		t.Fatalf("key1 should be expired")
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 without ttl should never expire")
+	}
+	if reasons["key1"] != EvictExpired || lru.nbytes != int64(len("key2")+len("5678")) {
+		t.Fatalf("expected key1 evicted by %s, got %v", EvictExpired, reasons)
+	}
+}
+
+// 测试RemoveExpired方法，以及过期淘汰与容量淘汰的原因区分
+func TestRemoveExpired(t *testing.T) {
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(len("k1v1k2v2k3v3")), nil)
+	lru.OnEvictedWithReason = func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	}
+	lru.AddWithTTL("k1", String("v1"), time.Hour)
+	lru.AddWithTTL("k2", String("v2"), 10*time.Millisecond)
+	lru.AddWithTTL("k3", String("v3"), 10*time.Millisecond)
+	lru.Add("k4", String("v4"))
+
+	time.Sleep(20 * time.Millisecond)
+	if n := lru.RemoveExpired(); n != 2 || lru.Len() != 1 {
+		t.Fatalf("expected 2 expired entries removed, got %d, len %d", n, lru.Len())
+	}
+	expect := map[string]EvictReason{"k1": EvictCapacity, "k2": EvictExpired, "k3": EvictExpired}
+	if !reflect.DeepEqual(expect, reasons) {
+		t.Fatalf("expected evict reasons %v, got %v", expect, reasons)
+	}
+}
+
+// 测试Remove方法
+func TestRemove(t *testing.T) {
+	var reason EvictReason
+	lru := New(int64(0), nil)
+	lru.OnEvictedWithReason = func(key string, value Value, r EvictReason) {
+		reason = r
+	}
+	lru.Add("key1", String("1234"))
+	lru.Remove("key1")
+	lru.Remove("key2")
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 || lru.nbytes != 0 || reason != EvictRemoved {
+		t.Fatalf("Remove key1 failed")
+	}
+}
+
+// 测试泛型 Cache：自定义键值类型和 size 函数
+func TestGenericCache(t *testing.T) {
+	var evicted []int
+	c := NewCache[int, []byte](8, func(key int, value []byte) int64 {
+		return int64(len(value))
+	})
+	c.OnEvicted = func(key int, value []byte) {
+		evicted = append(evicted, key)
+	}
+	c.Add(1, []byte("1234"))
+	c.Add(2, []byte("5678"))
+	if v, ok := c.Get(1); !ok || string(v) != "1234" { // 1 变为最近访问
+		t.Fatalf("cache hit 1=1234 failed")
+	}
+	c.Add(3, []byte("90"))
+	if _, ok := c.Get(2); ok || !reflect.DeepEqual(evicted, []int{2}) {
+		t.Fatalf("expected 2 evicted, got %v", evicted)
+	}
+	c.Add(1, []byte("1")) // 更新值时重新计算大小
+	if c.Bytes() != 3 || c.Len() != 2 {
+		t.Fatalf("unexpected bytes %d, len %d", c.Bytes(), c.Len())
+	}
+
+	// size 为 nil 时每条记录算作 1，maxBytes 即最大条数
+	counted := NewCache[string, int](2, nil)
+	counted.Add("a", 1)
+	counted.Add("b", 2)
+	counted.Add("c", 3)
+	if _, ok := counted.Get("a"); ok || counted.Len() != 2 {
+		t.Fatalf("expected a evicted by count limit")
+	}
+}
+
+// 测试 Peek、Contains、Keys 和 Range 不改变访问顺序
+func TestPeekKeysRange(t *testing.T) {
+	lru := New(int64(0), nil)
+	lru.Add("k1", String("1"))
+	lru.Add("k2", String("2"))
+	lru.Add("k3", String("3"))
+	lru.AddWithTTL("expired", String("0"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	if v, ok := lru.Peek("k1"); !ok || string(v.(String)) != "1" {
+		t.Fatalf("peek k1 failed")
+	}
+	if _, ok := lru.Peek("expired"); ok || lru.Contains("expired") || !lru.Contains("k2") {
+		t.Fatalf("expired records should not be visible")
+	}
+	if keys := lru.Keys(); !reflect.DeepEqual(keys, []string{"k3", "k2", "k1"}) {
+		t.Fatalf("expected keys from most to least recent, got %v", keys)
+	}
+
+	lru.Get("k1")
+	var keys []string
+	lru.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return len(keys) < 2
+	})
+	if !reflect.DeepEqual(keys, []string{"k1", "k3"}) {
+		t.Fatalf("Range should stop when f returns false, got %v", keys)
+	}
+}
+
+// 测试 Clear 触发回调，SetMaxBytes 淘汰到新的上限
+func TestClearAndSetMaxBytes(t *testing.T) {
+	var evicted []string
+	lru := New(int64(0), func(key string, value Value) {
+		evicted = append(evicted, key)
+	})
+	lru.Add("k1", String("1"))
+	lru.Add("k2", String("2"))
+	lru.Add("k3", String("3"))
+
+	lru.SetMaxBytes(4) // 每条记录 3 字节，只能保留 1 条
+	if !reflect.DeepEqual(evicted, []string{"k1", "k2"}) || lru.Len() != 1 || lru.Bytes() != 3 {
+		t.Fatalf("SetMaxBytes should evict down to the limit, evicted %v", evicted)
+	}
+
+	evicted = nil
+	lru.SetMaxBytes(0)
+	lru.Add("k4", String("4"))
+	lru.Clear()
+	if !reflect.DeepEqual(evicted, []string{"k3", "k4"}) || lru.Len() != 0 || lru.Bytes() != 0 {
+		t.Fatalf("Clear should remove everything, evicted %v", evicted)
+	}
+}
@@ -0,0 +1,213 @@
+package geecache
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 延迟直方图默认的桶上界，单位是秒
+var defaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+// histogram 是可以并发写入的延迟直方图，按 Prometheus 的 histogram 类型输出
+type histogram struct {
+	bounds  []float64   // 各个桶的上界，升序
+	counts  []AtomicInt // counts[i] 是落在 (bounds[i-1], bounds[i]] 中的次数，最后一个是 +Inf 桶
+	count   AtomicInt
+	sumBits uint64 // float64 的总和，用 math.Float64bits 存储以便原子更新
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]AtomicInt, len(bounds)+1),
+	}
+}
+
+// observe 记录一次耗时
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	h.counts[sort.SearchFloat64s(h.bounds, v)].Add(1)
+	h.count.Add(1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			return
+		}
+	}
+}
+
+// metricsWriter 按 Prometheus 文本格式(text/plain; version=0.0.4)写出指标
+type metricsWriter struct {
+	w     *bufio.Writer
+	typed map[string]bool // 已经写过 HELP 和 TYPE 的指标
+}
+
+// header 每个指标只在第一次出现时写 HELP 和 TYPE
+func (m *metricsWriter) header(name, typ, help string) {
+	if m.typed[name] {
+		return
+	}
+	m.typed[name] = true
+	fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample 写出一行样本，labels 依次是标签名和标签值
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		m.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				m.w.WriteByte(',')
+			}
+			fmt.Fprintf(m.w, "%s=\"%s\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		m.w.WriteByte('}')
+	}
+	m.w.WriteByte(' ')
+	m.w.WriteString(formatFloat(value))
+	m.w.WriteByte('\n')
+}
+
+func (m *metricsWriter) counter(name, help string, value int64, labels ...string) {
+	m.header(name, "counter", help)
+	m.sample(name, float64(value), labels...)
+}
+
+func (m *metricsWriter) gauge(name, help string, value int64, labels ...string) {
+	m.header(name, "gauge", help)
+	m.sample(name, float64(value), labels...)
+}
+
+// histogram 写出累计的桶计数、总和与次数
+func (m *metricsWriter) histogram(name, help string, h *histogram, labels ...string) {
+	m.header(name, "histogram", help)
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Get()
+		m.sample(name+"_bucket", float64(cumulative), append(labels, "le", formatFloat(bound))...)
+	}
+	cumulative += h.counts[len(h.bounds)].Get()
+	m.sample(name+"_bucket", float64(cumulative), append(labels, "le", "+Inf")...)
+	m.sample(name+"_sum", math.Float64frombits(atomic.LoadUint64(&h.sumBits)), labels...)
+	m.sample(name+"_count", float64(h.count.Get()), labels...)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// writeGroupMetrics 写出所有 group 的指标
+func writeGroupMetrics(m *metricsWriter) {
+	all := allGroups()
+	names := make([]string, 0, len(all))
+	for name := range all {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		g := all[name]
+		s := g.Stats()
+		m.counter("geecache_gets_total", "Total Get requests.", s.Gets, "group", name)
+		m.counter("geecache_misses_total", "Get requests that missed both caches.", s.Loads, "group", name)
+		m.counter("geecache_loads_deduped_total", "Loads actually executed after singleflight deduplication.", s.LoadsDeduped, "group", name)
+		m.counter("geecache_peer_loads_total", "Values loaded from peers.", s.PeerLoads, "group", name)
+		m.counter("geecache_peer_errors_total", "Failed loads from peers.", s.PeerErrors, "group", name)
+		m.counter("geecache_local_loads_total", "Values loaded from the local Getter.", s.LocalLoads, "group", name)
+		m.counter("geecache_local_load_errors_total", "Failed loads from the local Getter.", s.LocalLoadErrs, "group", name)
+		m.counter("geecache_negative_hits_total", "Get requests answered with ErrNotFound from the negative cache.", s.NegativeHits, "group", name)
+		m.counter("geecache_bloom_rejects_total", "Get requests rejected by the bloom filter.", s.BloomRejects, "group", name)
+		m.counter("geecache_stale_hits_total", "Hits served past their soft deadline in refresh-ahead mode.", s.StaleHits, "group", name)
+		m.counter("geecache_refreshes_total", "Background reloads started in refresh-ahead mode.", s.Refreshes, "group", name)
+		for _, c := range []struct {
+			name  string
+			stats CacheStats
+		}{{"main", s.MainCache}, {"hot", s.HotCache}, {"negative", s.NegativeCache}} {
+			m.counter("geecache_cache_hits_total", "Cache hits.", c.stats.Hits, "group", name, "cache", c.name)
+			m.counter("geecache_cache_evictions_total", "Entries evicted by capacity or expiry.", c.stats.Evictions, "group", name, "cache", c.name)
+			m.gauge("geecache_cache_bytes", "Bytes used by the cache.", c.stats.Bytes, "group", name, "cache", c.name)
+			m.gauge("geecache_cache_items", "Entries in the cache.", c.stats.Items, "group", name, "cache", c.name)
+		}
+		m.histogram("geecache_load_duration_seconds", "Latency of deduplicated loads from peers or the local Getter.", g.loadLatency, "group", name)
+	}
+}
+
+// ServeMetrics 按 Prometheus 文本格式输出所有 group 的指标，
+// 以及本节点访问各个远程节点的延迟，通常挂在 /metrics 上
+func (p *HTTPPool) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m := &metricsWriter{w: bufio.NewWriter(w), typed: make(map[string]bool)}
+	writeGroupMetrics(m)
+
+	p.mu.Lock()
+	peers := make([]string, 0, len(p.peerStates))
+	for peer := range p.peerStates {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+	states := make([]*peerState, len(peers))
+	for i, peer := range peers {
+		states[i] = p.peerStates[peer]
+	}
+	p.mu.Unlock()
+
+	peerCounters := []struct {
+		name, help string
+		value      func(st *peerState) int64
+	}{
+		{"geecache_peer_requests_total", "Requests sent to each peer, not counting retries.", func(st *peerState) int64 { return st.requests.Get() }},
+		{"geecache_peer_retries_total", "Retried requests to each peer.", func(st *peerState) int64 { return st.retries.Get() }},
+		{"geecache_peer_request_errors_total", "Requests to each peer that failed after retries.", func(st *peerState) int64 { return st.errors.Get() }},
+		{"geecache_peer_failovers_total", "Requests failed over from each peer to the next replica.", func(st *peerState) int64 { return st.failovers.Get() }},
+		{"geecache_peer_migrated_entries_total", "Cache entries pulled from each peer during key migration.", func(st *peerState) int64 { return st.migrated.Get() }},
+		{"geecache_peer_breaker_trips_total", "Times the circuit breaker of each peer opened.", func(st *peerState) int64 { return st.breaker.stats().Trips }},
+	}
+	for _, c := range peerCounters {
+		for i, peer := range peers {
+			m.counter(c.name, c.help, c.value(states[i]), "peer", peer)
+		}
+	}
+	for i, peer := range peers {
+		m.gauge("geecache_peer_in_flight_requests", "Requests to each peer that have not completed.", states[i].inflight.Get(), "peer", peer)
+	}
+	for i, peer := range peers {
+		m.gauge("geecache_peer_breaker_state", "Circuit breaker state of each peer: 0 closed, 1 open, 2 half-open.", int64(states[i].breaker.stats().State), "peer", peer)
+	}
+	for i, peer := range peers {
+		m.histogram("geecache_peer_request_duration_seconds", "Latency of requests sent to each peer.", states[i].latency, "peer", peer)
+	}
+	writeRingMismatches(m, p.ringMismatches.Get())
+	m.w.Flush()
+}
+
+// ServeMetrics 按 Prometheus 文本格式输出所有 group 的指标，以及哈希环指纹不一致的次数。
+// gRPC 服务不处理 HTTP 请求，需要把它挂在单独的 HTTP 服务上
+func (p *GRPCPool) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m := &metricsWriter{w: bufio.NewWriter(w), typed: make(map[string]bool)}
+	writeGroupMetrics(m)
+	writeRingMismatches(m, p.ringMismatches.Get())
+	m.w.Flush()
+}
+
+func writeRingMismatches(m *metricsWriter, n int64) {
+	m.counter("geecache_ring_mismatches_total", "Peer requests whose ring fingerprint differed from this node's.", n)
+}
@@ -0,0 +1,102 @@
+package geecache
+
+import (
+	"bufio"
+	"context"
+	pb "geecache/geecachepb"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrape 模拟 Prometheus 抓取 /metrics，返回"指标名{标签}"到样本值的映射，
+// 同时检查每个样本之前都声明过 TYPE
+func scrape(t *testing.T, url string) map[string]float64 {
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	samples := make(map[string]float64)
+	typed := make(map[string]string)
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# TYPE ") {
+			fields := strings.Fields(line)
+			typed[fields[2]] = fields[3]
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		series, value := line[:i], line[i+1:]
+		name := series
+		if j := strings.IndexByte(series, '{'); j >= 0 {
+			name = series[:j]
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		if typed[name] == "" && typed[base] != "histogram" {
+			t.Fatalf("sample %s without TYPE", series)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			t.Fatalf("bad sample value in %q", line)
+		}
+		samples[series] = v
+	}
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	gee := NewGroup("metrics-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(db[key]), nil
+		}))
+	gee.Get("Tom")
+	gee.Get("Tom")
+
+	peer := httptest.NewServer(NewHTTPPool(""))
+	defer peer.Close()
+	pool := NewHTTPPool("self")
+	pool.Set(peer.URL)
+	getter, _ := pool.PickPeer("Tom")
+	if err := getter.Get(context.Background(), &pb.Request{Group: gee.name, Key: "Tom"}, &pb.Response{}); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(pool.ServeMetrics))
+	defer server.Close()
+	samples := scrape(t, server.URL)
+
+	expect := map[string]float64{
+		`geecache_gets_total{group="metrics-scores"}`:                                      3,
+		`geecache_misses_total{group="metrics-scores"}`:                                    1,
+		`geecache_cache_hits_total{group="metrics-scores",cache="main"}`:                   2,
+		`geecache_cache_items{group="metrics-scores",cache="main"}`:                        1,
+		`geecache_load_duration_seconds_count{group="metrics-scores"}`:                     1,
+		`geecache_load_duration_seconds_bucket{group="metrics-scores",le="+Inf"}`:          1,
+		`geecache_peer_requests_total{peer="` + peer.URL + `"}`:                            1,
+		`geecache_peer_breaker_state{peer="` + peer.URL + `"}`:                             0,
+		`geecache_peer_request_duration_seconds_count{peer="` + peer.URL + `"}`:            1,
+		`geecache_peer_request_duration_seconds_bucket{peer="` + peer.URL + `",le="+Inf"}`: 1,
+	}
+	for series, v := range expect {
+		if got, ok := samples[series]; !ok || got != v {
+			t.Errorf("expected %s = %v, got %v (present %v)", series, v, got, ok)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Fatalf("unexpected escaped label %s", got)
+	}
+}
@@ -0,0 +1,505 @@
+package geecache
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// 节点变化时的 key 迁移（warm handoff）：
+// 哈希环变化后，新的所有者通过 Transfer（gRPC 的流式 RPC，或 HTTP 的 _transfer 接口）从原来的所有者拉取新分到的区间内最热的缓存记录，
+// 避免这些 key 在新节点上全部未命中，数据源承受突发的加载。
+// 拉取完成之前，新的所有者继续把这些 key 交给原来的所有者；原来的所有者在被拉取期间
+// 也把这些 key 当作自己的，由本地处理，两者之间不会来回转发。
+
+const (
+	// migrationLinger 是传输结束后，原来的所有者继续在本地处理这些 key 的时间，
+	// 覆盖新的所有者在传输结束前转发过来、尚未完成的请求
+	migrationLinger = 2 * time.Second
+	// maxMigrationHistory 是保留的已结束迁移的条数
+	maxMigrationHistory = 16
+	// maxEntrySize 是迁移中单条记录编码后的大小上限，防止读到损坏的长度时分配过多内存
+	maxEntrySize = 64 << 20
+)
+
+// 迁移的状态
+const (
+	migrationRunning  = "running"
+	migrationDone     = "done"
+	migrationFailed   = "failed"
+	migrationCanceled = "canceled"
+)
+
+// migration 是本节点参与的一次迁移。incoming 为 true 时本节点是新的所有者，从 peer 拉取所有 group 中
+// 落在 ranges 内的记录；否则是 peer 正在从本节点拉取 group 的记录。除计数器外的字段由 migrator.mu 保护。
+type migration struct {
+	peer     string
+	group    string // 只有 peer 拉取本节点时才有，本节点拉取时包含所有 group
+	incoming bool
+	ranges   []*pb.KeyRange
+	getter   PeerGetter         // incoming 时访问原来的所有者，实现了 TransferPeer
+	migrated *AtomicInt         // incoming 时按节点统计迁移来的记录，可以为 nil
+	release  func()             // incoming 时拉取结束后调用，可以为 nil
+	cancel   context.CancelFunc // incoming 时取消拉取
+	active   bool               // 是否仍在改变这些 key 的路由
+	state    string
+	err      error
+	started  time.Time
+	finished time.Time
+	keys     AtomicInt // 已传输的记录数
+	bytes    AtomicInt // 已传输的记录的大小
+}
+
+// covers 判断哈希值是否落在迁移的区间内
+func (m *migration) covers(hash uint64) bool {
+	return inRanges(m.ranges, hash)
+}
+
+func inRanges(ranges []*pb.KeyRange, hash uint64) bool {
+	for _, r := range ranges {
+		if r.GetStart() <= hash && hash <= r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrationStats 是一次迁移的进度
+type MigrationStats struct {
+	Peer      string     `json:"peer"`
+	Group     string     `json:"group,omitempty"` // 为空表示所有 group
+	Direction string     `json:"direction"`       // in 表示本节点从 Peer 拉取，out 表示 Peer 从本节点拉取
+	Ranges    int        `json:"ranges"`          // 迁移的区间数
+	State     string     `json:"state"`           // running、done、failed 或 canceled
+	Keys      int64      `json:"keys"`            // 已传输的记录数
+	Bytes     int64      `json:"bytes"`           // 已传输的记录的大小
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// migrator 管理本节点参与的迁移，HTTPPool 和 GRPCPool 共用，两者只是传输记录的方式不同。
+// 它有自己的锁，pool 可以在持有自己的锁时调用它，反过来则不行
+type migrator struct {
+	self    string
+	log     func(format string, v ...interface{})
+	enabled bool // 哈希环变化时是否从原来的所有者迁移缓存记录
+	limit   int  // 每个 group 最多迁移的记录数，<= 0 表示不限制
+
+	mu         sync.Mutex   // guards migrations and the fields of each migration
+	migrations []*migration // 进行中和最近结束的迁移，按开始时间排序
+}
+
+// stats 返回进行中和最近结束的迁移的进度，按开始时间排序
+func (mg *migrator) stats() []MigrationStats {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	stats := make([]MigrationStats, len(mg.migrations))
+	for i, m := range mg.migrations {
+		s := MigrationStats{
+			Peer:      m.peer,
+			Group:     m.group,
+			Direction: "out",
+			Ranges:    len(m.ranges),
+			State:     m.state,
+			Keys:      m.keys.Get(),
+			Bytes:     m.bytes.Get(),
+			Started:   m.started,
+		}
+		if m.incoming {
+			s.Direction = "in"
+		}
+		if !m.finished.IsZero() {
+			finished := m.finished
+			s.Finished = &finished
+		}
+		if m.err != nil {
+			s.Error = m.err.Error()
+		}
+		stats[i] = s
+	}
+	return stats
+}
+
+// Migrations 返回进行中和最近结束的迁移的进度，按开始时间排序
+func (p *HTTPPool) Migrations() []MigrationStats {
+	return p.migrator.stats()
+}
+
+// Migrations 返回进行中和最近结束的迁移的进度，按开始时间排序
+func (p *GRPCPool) Migrations() []MigrationStats {
+	return p.migrator.stats()
+}
+
+// serveMigrations 以 JSON 格式返回迁移进度
+func (p *HTTPPool) serveMigrations(w http.ResponseWriter) {
+	body, err := json.Marshal(p.Migrations())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// addMigration 记录一次迁移，超出 maxMigrationHistory 时丢弃最早结束的记录。调用方需持有 mg.mu
+func (mg *migrator) addMigration(m *migration) {
+	mg.migrations = append(mg.migrations, m)
+	for i := 0; len(mg.migrations) > maxMigrationHistory && i < len(mg.migrations); {
+		if old := mg.migrations[i]; old.state != migrationRunning && !old.active {
+			mg.migrations = append(mg.migrations[:i], mg.migrations[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+// route 返回正在改变 key 路由的迁移：incoming 时 key 交给返回的 getter，否则由本节点处理。
+// 没有这样的迁移，或者 peers 不是哈希环时 ok 为 false
+func (mg *migrator) route(peers consistenthash.Placement, key string) (getter PeerGetter, ok bool) {
+	ring, isRing := peers.(*consistenthash.Map)
+	if !isRing {
+		return nil, false
+	}
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	var hash uint64
+	hashed := false
+	for _, m := range mg.migrations {
+		if !m.active {
+			continue
+		}
+		if !hashed {
+			hash, hashed = ring.Hash(key), true
+		}
+		if !m.covers(hash) {
+			continue
+		}
+		if !m.incoming { // 其他节点正在从本节点拉取，拉取完成前仍由本节点处理
+			mg.log("key %s is migrating to %s, served locally", key, m.peer)
+			return nil, true
+		}
+		mg.log("key %s is migrating from %s, pick it", key, m.peer)
+		return m.getter, true
+	}
+	return nil, false
+}
+
+// start 为哈希环变化后本节点新分到的区间启动迁移，每个原来的所有者一次。
+// 之前还在进行的拉取被取消，它们的区间已经不准确了。
+// dial 返回访问原来的所有者的 getter，以及可选的计数器和拉取结束后的清理函数
+func (mg *migrator) start(moved []consistenthash.Move, dial func(peer string) (getter PeerGetter, migrated *AtomicInt, release func())) {
+	if !mg.enabled {
+		return
+	}
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	for _, m := range mg.migrations {
+		if m.incoming && m.state == migrationRunning {
+			m.cancel()
+		}
+	}
+	var peers []string
+	ranges := make(map[string][]*pb.KeyRange)
+	for _, mv := range moved {
+		if mv.To != mg.self || mv.From == "" || mv.From == mg.self {
+			continue
+		}
+		if ranges[mv.From] == nil {
+			peers = append(peers, mv.From)
+		}
+		ranges[mv.From] = append(ranges[mv.From], &pb.KeyRange{Start: mv.Start, End: mv.End})
+	}
+	for _, peer := range peers {
+		getter, migrated, release := dial(peer) // 被删除的节点也可以拉取，它可能还在运行
+		if getter == nil {
+			mg.log("cannot migrate from %s: no client", peer)
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		m := &migration{
+			peer:     peer,
+			incoming: true,
+			ranges:   ranges[peer],
+			getter:   getter,
+			migrated: migrated,
+			release:  release,
+			cancel:   cancel,
+			active:   true,
+			state:    migrationRunning,
+			started:  time.Now(),
+		}
+		mg.addMigration(m)
+		mg.log("migrating %d key ranges from %s", len(m.ranges), peer)
+		go mg.pull(ctx, m)
+	}
+}
+
+// pull 依次从原来的所有者拉取每个 group 的记录，只保存本节点还没有的 key。
+// 一个 group 失败不影响其他 group，全部结束后这些 key 恢复正常的路由。
+func (mg *migrator) pull(ctx context.Context, m *migration) {
+	defer m.cancel()
+	if m.release != nil {
+		defer m.release()
+	}
+	groups := allGroups()
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var firstErr error
+	peer, ok := m.getter.(TransferPeer)
+	if !ok {
+		firstErr = fmt.Errorf("peer %T does not support transfer", m.getter)
+		names = nil
+	}
+	for _, name := range names {
+		g := groups[name]
+		req := &pb.TransferRequest{Group: name, Ranges: m.ranges, Owner: mg.self, Limit: int64(mg.limit)}
+		err := peer.Transfer(ctx, req, func(e *pb.Entry) error {
+			if g.warm(e) {
+				m.keys.Add(1)
+				m.bytes.Add(int64(len(e.GetKey()) + len(e.GetValue())))
+				if m.migrated != nil {
+					m.migrated.Add(1)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				firstErr = ctx.Err()
+				break
+			}
+			mg.log("migrating group %s from %s failed: %v", name, m.peer, err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("group %s: %v", name, err)
+			}
+		}
+	}
+	mg.finish(m, firstErr)
+	mg.log("migration from %s finished: %d keys, %d bytes", m.peer, m.keys.Get(), m.bytes.Get())
+}
+
+// finish 记录迁移的结果。本节点拉取的迁移立即恢复正常路由，
+// 被拉取的迁移在 migrationLinger 之后才恢复
+func (mg *migrator) finish(m *migration, err error) {
+	mg.mu.Lock()
+	defer mg.mu.Unlock()
+	m.finished = time.Now()
+	m.err = err
+	switch {
+	case err == nil:
+		m.state = migrationDone
+	case errors.Is(err, context.Canceled):
+		m.state = migrationCanceled
+	default:
+		m.state = migrationFailed
+	}
+	if m.incoming {
+		m.active = false
+		return
+	}
+	time.AfterFunc(migrationLinger, func() {
+		mg.mu.Lock()
+		m.active = false
+		mg.mu.Unlock()
+	})
+}
+
+// serve 处理新的所有者发来的迁移请求：用 send 逐条发送 group 的 mainCache 中
+// 哈希值落在请求区间内的记录，最热的在前。传输期间及之后的 migrationLinger 内，这些 key 由本节点处理
+func (mg *migrator) serve(group *Group, peers consistenthash.Placement, req *pb.TransferRequest, send func(*pb.Entry) error) error {
+	ring, ok := peers.(*consistenthash.Map)
+	if !ok {
+		return errTransferNeedsRing
+	}
+	m := &migration{
+		peer:    req.GetOwner(),
+		group:   group.name,
+		ranges:  req.GetRanges(),
+		active:  true,
+		state:   migrationRunning,
+		started: time.Now(),
+	}
+	mg.mu.Lock()
+	mg.addMigration(m)
+	mg.mu.Unlock()
+
+	entries := group.transferEntries(func(key string) bool {
+		return inRanges(req.GetRanges(), ring.Hash(key))
+	}, int(req.GetLimit()))
+	var err error
+	for _, e := range entries {
+		if err = send(e); err != nil {
+			break
+		}
+		m.keys.Add(1)
+		m.bytes.Add(int64(len(e.GetKey()) + len(e.GetValue())))
+	}
+	mg.finish(m, err)
+	return err
+}
+
+// errTransferNeedsRing 表示本节点没有使用哈希环，无法按区间发送记录
+var errTransferNeedsRing = errors.New("transfer requires the hash ring")
+
+// dialMigration 返回从 peer 拉取记录的 httpGetter，迁移来的记录计入 peer 的统计。调用方需持有 p.mu
+func (p *HTTPPool) dialMigration(peer string) (PeerGetter, *AtomicInt, func()) {
+	getter := p.newGetter(peer)
+	return getter, &getter.state.migrated, nil
+}
+
+// serveTransfer 处理新的所有者发来的迁移请求，以带长度前缀的 pb.Entry 流的形式发送记录，
+// 与 gRPC 的 Transfer 流内容相同
+func (p *HTTPPool) serveTransfer(w http.ResponseWriter, r *http.Request, groupname string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	group := GetGroup(groupname)
+	if group == nil {
+		http.Error(w, "no such group: "+groupname, http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &pb.TransferRequest{}
+	if err = proto.Unmarshal(body, req); err != nil {
+		http.Error(w, "decoding request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	p.mu.Lock()
+	peers := p.peers
+	p.mu.Unlock()
+	if _, ok := peers.(*consistenthash.Map); !ok {
+		http.Error(w, errTransferNeedsRing.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	p.migrator.serve(group, peers, req, func(e *pb.Entry) error {
+		return writeEntry(w, e)
+	})
+}
+
+// transferEntries 返回 mainCache 中满足 match 的记录，最多 limit 条，limit <= 0 表示不限制
+func (g *Group) transferEntries(match func(key string) bool, limit int) []*pb.Entry {
+	collected := g.mainCache.collect(match, limit)
+	entries := make([]*pb.Entry, len(collected))
+	for i, c := range collected {
+		entries[i] = &pb.Entry{Key: c.key, Value: c.value.ByteSlice()}
+		if !c.value.e.IsZero() {
+			entries[i].Expire = c.value.e.UnixNano()
+		}
+	}
+	return entries
+}
+
+// warm 保存迁移来的记录，返回是否保存。本节点已经有这个 key 时保留本节点的值，它不会比迁移来的旧
+func (g *Group) warm(e *pb.Entry) bool {
+	view := ByteView{b: e.GetValue()}
+	if e.GetExpire() != 0 {
+		view.e = time.Unix(0, e.GetExpire())
+	}
+	if !g.mainCache.addIfAbsent(e.GetKey(), view) {
+		return false
+	}
+	g.bloom.add(e.GetKey())
+	g.negCache.remove(e.GetKey())
+	return true
+}
+
+// writeEntry 写出一条带 uvarint 长度前缀的记录
+func writeEntry(w io.Writer, e *pb.Entry) error {
+	data, err := proto.Marshal(e)
+	if err != nil {
+		return err
+	}
+	var prefix [binary.MaxVarintLen64]byte
+	if _, err = w.Write(prefix[:binary.PutUvarint(prefix[:], uint64(len(data)))]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// readEntry 读取一条带长度前缀的记录，流正常结束时返回 io.EOF
+func readEntry(r *bufio.Reader, e *pb.Entry) error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return err
+	}
+	if n > maxEntrySize {
+		return fmt.Errorf("entry of %d bytes exceeds the limit", n)
+	}
+	data := make([]byte, n)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return proto.Unmarshal(data, e)
+}
+
+// Transfer 使用 POST 请求从远程节点拉取迁移的记录，对每条记录调用 fn，实现了 TransferPeer。
+// 传输可能持续较长时间，不使用单次请求的超时，也不重试，由 ctx 控制
+func (h *httpGetter) Transfer(ctx context.Context, in *pb.TransferRequest, fn func(*pb.Entry) error) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	u := h.baseURL + transferPath + "/" + url.QueryEscape(in.GetGroup())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	br := bufio.NewReader(res.Body)
+	for {
+		e := &pb.Entry{}
+		if err = readEntry(br, e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading response body: %v", err)
+		}
+		if err = fn(e); err != nil {
+			return err
+		}
+	}
+}
+
+var _ TransferPeer = (*httpGetter)(nil)
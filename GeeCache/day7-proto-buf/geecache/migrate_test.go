@@ -0,0 +1,145 @@
+package geecache
+
+import (
+	"bufio"
+	"context"
+	"geecache/consistenthash"
+	pb "geecache/geecachepb"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 原来的所有者按区间发送缓存记录，传输结束后短时间内仍在本地处理这些 key
+func TestTransfer(t *testing.T) {
+	gee := NewGroup("transfer-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	for i := 0; i < 100; i++ {
+		gee.populateCache("key"+strconv.Itoa(i), ByteView{b: []byte("v" + strconv.Itoa(i))})
+	}
+	pool := NewHTTPPool("http://src")
+	pool.Set("http://src", "http://dst")
+	server := httptest.NewServer(pool)
+	defer server.Close()
+
+	ring := pool.peers.(*consistenthash.Map)
+	var ranges []*pb.KeyRange
+	for _, r := range ring.Ranges() {
+		if r.Owner == "http://dst" {
+			ranges = append(ranges, &pb.KeyRange{Start: r.Start, End: r.End})
+		}
+	}
+	var want []string
+	for i := 0; i < 100; i++ {
+		if key := "key" + strconv.Itoa(i); ring.Get(key) == "http://dst" {
+			want = append(want, key)
+		}
+	}
+
+	peer := &httpGetter{baseURL: server.URL + defaultBasePath}
+	req := &pb.TransferRequest{Group: gee.name, Ranges: ranges, Owner: "http://dst"}
+	var got []string
+	err := peer.Transfer(context.Background(), req, func(e *pb.Entry) error {
+		if string(e.GetValue()) != "v"+strings.TrimPrefix(e.GetKey(), "key") {
+			t.Errorf("unexpected value %q for %s", e.GetValue(), e.GetKey())
+		}
+		got = append(got, e.GetKey())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the keys owned by dst %v, got %v", want, got)
+	}
+
+	// 被拉取的 key 在 migrationLinger 内仍由本节点处理
+	if _, ok := pool.PickPeer(want[0]); ok {
+		t.Fatalf("%s is being migrated and should be served locally", want[0])
+	}
+	stats := pool.Migrations()
+	if len(stats) != 1 || stats[0].Direction != "out" || stats[0].State != migrationDone || stats[0].Keys != int64(len(want)) {
+		t.Fatalf("unexpected migration stats %+v", stats)
+	}
+
+	req.Limit = 5
+	n := 0
+	if err = peer.Transfer(context.Background(), req, func(e *pb.Entry) error { n++; return nil }); err != nil || n != 5 {
+		t.Fatalf("expected 5 entries with limit, got %d (%v)", n, err)
+	}
+	req.Group = "no-such-group"
+	if err = peer.Transfer(context.Background(), req, func(e *pb.Entry) error { return nil }); err == nil {
+		t.Fatal("expected error for unknown group")
+	}
+}
+
+// 新的所有者从原来的所有者拉取记录，拉取完成前 key 仍然交给原来的所有者
+func TestMigration(t *testing.T) {
+	gee := NewGroup("migration-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.populateCache("Sam", ByteView{b: []byte("new")}) // 本节点已有的值不会被覆盖
+	release := make(chan struct{})
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultBasePath+transferPath+"/"+gee.name { // 其他 group 没有记录
+			return
+		}
+		<-release
+		bw := bufio.NewWriter(w)
+		writeEntry(bw, &pb.Entry{Key: "Tom", Value: []byte("630")})
+		writeEntry(bw, &pb.Entry{Key: "Sam", Value: []byte("old")})
+		bw.Flush()
+	}))
+	defer old.Close()
+
+	pool := NewHTTPPool("http://new", WithMigration(0))
+	pool.Set(old.URL)
+	moved := pool.AddPeers("http://new")
+	ring := pool.peers.(*consistenthash.Map)
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := "key" + strconv.Itoa(i); ring.Get(k) == "http://new" {
+			key = k
+		}
+	}
+
+	getter, ok := pool.PickPeer(key)
+	if !ok || getter.(*httpGetter).baseURL != old.URL+defaultBasePath {
+		t.Fatalf("%s should be served by the previous owner during migration", key)
+	}
+	stats := pool.Migrations()
+	if len(stats) != 1 || stats[0].Direction != "in" || stats[0].State != migrationRunning || stats[0].Ranges != len(moved) {
+		t.Fatalf("unexpected migration stats %+v", stats)
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Migrations()[0].State == migrationRunning && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats = pool.Migrations(); stats[0].State != migrationDone || stats[0].Keys != 1 {
+		t.Fatalf("expected migration done with 1 key, got %+v", stats[0])
+	}
+	if v, ok := gee.mainCache.get("Tom"); !ok || v.String() != "630" {
+		t.Fatalf("expected Tom migrated, got %q", v)
+	}
+	if v, _ := gee.mainCache.get("Sam"); v.String() != "new" {
+		t.Fatalf("migration should not overwrite Sam, got %q", v)
+	}
+	if _, ok := pool.PickPeer(key); ok {
+		t.Fatalf("%s should be served locally after migration", key)
+	}
+	if n := pool.PeerStats()[old.URL].Migrated; n != 1 {
+		t.Fatalf("expected 1 entry migrated from %s, got %d", old.URL, n)
+	}
+}
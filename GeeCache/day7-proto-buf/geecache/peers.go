@@ -1,10 +1,22 @@
 package geecache
 
-import pb "geecache/geecachepb"
+import (
+	"context"
+	"fmt"
+	pb "geecache/geecachepb"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
 
 // PeerPicker 的 PickPeer() 方法用于根据传入的 key 选择相应节点 PeerGetter
 type PeerPicker interface {
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	// GetAll 返回除自己以外的所有节点，用于广播删除
+	GetAll() []PeerGetter
 }
 
 // PeerGetter是必须由peer实现的接口。
@@ -12,5 +24,143 @@ type PeerPicker interface {
 // PeerGetter 就对应于上述流程中的 HTTP 客户端。
 type PeerGetter interface {
 	// Get(group string, key string) ([]byte, error) // HTTP通信
-	Get(in *pb.Request, out *pb.Response) error
-}
\ No newline at end of file
+	// ctx 被取消或超时后，对远程节点的请求随之取消
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	// Set 将值写入远程节点的缓存
+	Set(ctx context.Context, in *pb.SetRequest, out *pb.Response) error
+	// Remove 从远程节点的缓存中删除一个键
+	Remove(ctx context.Context, in *pb.Request, out *pb.Response) error
+}
+
+// BloomFetcher 是 PeerGetter 可选实现的接口，用来获取远程节点上序列化后的布隆过滤器
+type BloomFetcher interface {
+	GetBloomFilter(ctx context.Context, group string) ([]byte, error)
+}
+
+// BatchPeerGetter 是 PeerGetter 可选实现的接口，用一次请求获取远程节点上的多个 key
+type BatchPeerGetter interface {
+	GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+}
+
+// TransferPeer 是 PeerGetter 可选实现的接口，以流的形式获取远程节点上哈希值落在指定区间的缓存记录，
+// 对每条记录调用 fn。节点变化时新的所有者用它从原来的所有者预热缓存
+type TransferPeer interface {
+	Transfer(ctx context.Context, in *pb.TransferRequest, fn func(*pb.Entry) error) error
+}
+
+// peerAddresser 是 PeerGetter 可选实现的接口，返回它访问的节点地址，
+// 访问多个副本时返回第一个副本的地址
+type peerAddresser interface {
+	peerAddr() string
+}
+
+// peerAddr 返回 PeerGetter 访问的节点地址，无法得知时返回空字符串
+func peerAddr(peer PeerGetter) string {
+	if a, ok := peer.(peerAddresser); ok {
+		return a.peerAddr()
+	}
+	return ""
+}
+
+// localLoadTracker 是 PeerPicker 可选实现的接口，Group 从本地数据源加载时通过它报告正在进行的加载数，
+// 有界负载模式据此计算本节点自己的负载。返回的函数在加载结束时调用
+type localLoadTracker interface {
+	trackLocalLoads(n int) (done func())
+}
+
+// RingFingerprinter 是 PeerPicker 可选实现的接口，返回本节点哈希环的指纹。
+// 指纹随请求发给远程节点，远程节点据此发现两边的节点列表不一致
+type RingFingerprinter interface {
+	RingFingerprint() uint64
+}
+
+// ringFingerprint 计算节点列表的指纹：按地址排序后对 "地址=权重" 做 FNV-1a 64 位哈希，
+// kind 区分选择节点的算法。节点、权重和算法都相同的两个节点指纹相同，没有节点时为 0
+func ringFingerprint(kind string, weights map[string]int) uint64 {
+	if len(weights) == 0 {
+		return 0
+	}
+	addrs := make([]string, 0, len(weights))
+	for addr := range weights {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	h := fnv.New64a()
+	io.WriteString(h, kind)
+	for _, addr := range addrs {
+		fmt.Fprintf(h, "\n%s=%d", addr, weights[addr])
+	}
+	return h.Sum64()
+}
+
+// newResponse 把缓存值编码成节点间传输的 pb.Response
+func newResponse(view ByteView) *pb.Response {
+	res := &pb.Response{Value: view.ByteSlice()}
+	if !view.e.IsZero() {
+		res.Expire = view.e.UnixNano()
+	}
+	return res
+}
+
+// viewFromResponse 把远程节点返回的 pb.Response 解码成缓存值
+func viewFromResponse(res *pb.Response) ByteView {
+	value := ByteView{b: res.GetValue()}
+	if res.GetExpire() != 0 { // 远程节点的值带有过期时间，hotCache 中的副本也随之过期
+		value.e = time.Unix(0, res.GetExpire())
+	}
+	return value
+}
+
+// setFromPeer 保存远程发来的值。hops > 0 表示其他节点转发来的值，发起方已经确认
+// 本节点是 key 的所有者，这里直接写入本地缓存，不再转发；否则与在本节点调用 Set 相同。
+func (g *Group) setFromPeer(in *pb.SetRequest) error {
+	view := ByteView{b: in.GetValue()}
+	if in.GetExpire() != 0 {
+		view.e = time.Unix(0, in.GetExpire())
+	}
+	if in.GetHops() > 0 {
+		g.populateCache(in.GetKey(), view)
+		return nil
+	}
+	var ttl time.Duration
+	if !view.e.IsZero() {
+		if ttl = time.Until(view.e); ttl <= 0 { // 已经过期的值没有必要保存
+			return nil
+		}
+	}
+	return g.Set(in.GetKey(), view.b, ttl)
+}
+
+// invalidateFromPeer 处理其他节点发来的删除通知：删除本节点的副本，并把 key 加入布隆过滤器。
+// Set 之后发起方也发送这个通知，本节点的过滤器此时还不知道新的 key，不加入的话 Get 会一直
+// 返回 ErrNotFound，直到下一次重建；通知来自 Remove 时，多加入一个 key 只是多一次误判
+func (g *Group) invalidateFromPeer(key string) {
+	g.bloom.add(key)
+	g.Invalidate(key)
+}
+
+// parsePeer 解析节点描述：可以直接是节点地址，也可以是 "peer=<地址>,weight=<权重>"。
+// 权重默认为 1，节点在哈希环上的虚拟节点数与权重成正比，容量大的节点可以设置更大的权重。
+func parsePeer(s string) (addr string, weight int, err error) {
+	if !strings.HasPrefix(s, "peer=") {
+		return s, 1, nil
+	}
+	weight = 1
+	for _, field := range strings.Split(s, ",") {
+		name, value, _ := strings.Cut(field, "=")
+		switch name {
+		case "peer":
+			addr = value
+		case "weight":
+			if weight, err = strconv.Atoi(value); err != nil || weight <= 0 {
+				return "", 0, fmt.Errorf("invalid weight in peer %q", s)
+			}
+		default:
+			return "", 0, fmt.Errorf("unknown field %q in peer %q", name, s)
+		}
+	}
+	if addr == "" {
+		return "", 0, fmt.Errorf("missing address in peer %q", s)
+	}
+	return addr, weight, nil
+}
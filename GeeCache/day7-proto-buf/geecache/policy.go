@@ -0,0 +1,139 @@
+package geecache
+
+import (
+	"geecache/arc"
+	"geecache/fifo"
+	"geecache/lfu"
+	"geecache/lru"
+	"geecache/tinylfu"
+	"time"
+)
+
+// Policy 是 cache 依赖的淘汰策略，值的类型固定为 ByteView。默认的 LRU 直接使用
+// lru.Cache[string, ByteView]，读写都不需要装箱和类型断言；fifo、lfu、arc、tinylfu
+// 包中的 Cache 不是泛型的，通过 valuePolicy 适配。
+// 实现不需要并发安全，cache 在调用前会加锁。
+type Policy interface {
+	Get(key string) (value ByteView, ok bool)
+	Add(key string, value ByteView)
+	AddWithTTL(key string, value ByteView, ttl time.Duration)
+	Remove(key string)
+	RemoveExpired() int
+	Len() int
+	Bytes() int64
+}
+
+// RangePolicy 是 Policy 可选实现的接口，按最应保留到最先淘汰的顺序遍历未过期的记录，
+// 迁移 key 时据此挑选最热的记录。LRU 和 FIFO 实现了它，其他策略不参与迁移。
+type RangePolicy interface {
+	Range(f func(key string, value ByteView) bool)
+}
+
+var (
+	_ RangePolicy = (*lru.Cache[string, ByteView])(nil)
+	_ RangePolicy = rangeValuePolicy{}
+)
+
+// valueCache 是值类型为 lru.Value 的非泛型淘汰策略
+type valueCache interface {
+	Get(key string) (value lru.Value, ok bool)
+	Add(key string, value lru.Value)
+	AddWithTTL(key string, value lru.Value, ttl time.Duration)
+	Remove(key string)
+	RemoveExpired() int
+	Len() int
+	Bytes() int64
+}
+
+// valuePolicy 把 valueCache 适配为 Policy，写入时装箱，读取时做类型断言
+type valuePolicy struct {
+	valueCache
+}
+
+func (p valuePolicy) Get(key string) (ByteView, bool) {
+	if v, ok := p.valueCache.Get(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+func (p valuePolicy) Add(key string, value ByteView) {
+	p.valueCache.Add(key, value)
+}
+
+func (p valuePolicy) AddWithTTL(key string, value ByteView, ttl time.Duration) {
+	p.valueCache.AddWithTTL(key, value, ttl)
+}
+
+// rangeValuePolicy 在 valuePolicy 的基础上实现 RangePolicy
+type rangeValuePolicy struct {
+	valuePolicy
+	rangeFunc func(f func(key string, value lru.Value) bool)
+}
+
+func (p rangeValuePolicy) Range(f func(key string, value ByteView) bool) {
+	p.rangeFunc(func(key string, value lru.Value) bool {
+		return f(key, value.(ByteView))
+	})
+}
+
+// boxedOnEvicted 把 onEvicted 转换为 valueCache 使用的回调
+func boxedOnEvicted(onEvicted func(string, ByteView, lru.EvictReason)) func(string, lru.Value, lru.EvictReason) {
+	if onEvicted == nil {
+		return nil
+	}
+	return func(key string, value lru.Value, reason lru.EvictReason) {
+		onEvicted(key, value.(ByteView), reason)
+	}
+}
+
+// PolicyFunc 创建一个淘汰策略，maxBytes 是内存上限，记录被移除时调用 onEvicted
+type PolicyFunc func(maxBytes int64, onEvicted func(key string, value ByteView, reason lru.EvictReason)) Policy
+
+// WithPolicy 设置 Group 的淘汰策略，mainCache 和 hotCache 都会使用它，默认是 LRU
+func WithPolicy(newPolicy PolicyFunc) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newPolicy = newPolicy
+		g.hotCache.newPolicy = newPolicy
+	}
+}
+
+// LRU 最近最少使用，淘汰最久没有访问的记录
+func LRU(maxBytes int64, onEvicted func(string, ByteView, lru.EvictReason)) Policy {
+	c := lru.NewCache[string, ByteView](maxBytes, byteViewSize)
+	c.OnEvictedWithReason = onEvicted
+	return c
+}
+
+// byteViewSize 与 lru.New 的计算方式相同：len(key) + value.Len()
+func byteViewSize(key string, value ByteView) int64 {
+	return int64(len(key)) + int64(value.Len())
+}
+
+// FIFO 先进先出，淘汰最早添加的记录
+func FIFO(maxBytes int64, onEvicted func(string, ByteView, lru.EvictReason)) Policy {
+	c := fifo.New(maxBytes, nil)
+	c.OnEvictedWithReason = boxedOnEvicted(onEvicted)
+	return rangeValuePolicy{valuePolicy{c}, c.Range}
+}
+
+// LFU 最少使用，淘汰访问次数最少的记录
+func LFU(maxBytes int64, onEvicted func(string, ByteView, lru.EvictReason)) Policy {
+	c := lfu.New(maxBytes, nil)
+	c.OnEvictedWithReason = boxedOnEvicted(onEvicted)
+	return valuePolicy{c}
+}
+
+// ARC 自适应替换缓存，根据访问模式在"最近"和"频率"之间自动调整
+func ARC(maxBytes int64, onEvicted func(string, ByteView, lru.EvictReason)) Policy {
+	c := arc.New(maxBytes, nil)
+	c.OnEvictedWithReason = boxedOnEvicted(onEvicted)
+	return valuePolicy{c}
+}
+
+// TinyLFU 即 W-TinyLFU，用频率估计决定新记录能否进入缓存，适合热点明显的访问模式
+func TinyLFU(maxBytes int64, onEvicted func(string, ByteView, lru.EvictReason)) Policy {
+	c := tinylfu.New(maxBytes, nil)
+	c.OnEvictedWithReason = boxedOnEvicted(onEvicted)
+	return valuePolicy{c}
+}
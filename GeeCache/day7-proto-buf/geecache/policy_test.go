@@ -0,0 +1,126 @@
+package geecache
+
+import (
+	"bufio"
+	"fmt"
+	"geecache/lru"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+var policies = []struct {
+	name      string
+	newPolicy PolicyFunc
+}{
+	{"LRU", LRU},
+	{"FIFO", FIFO},
+	{"LFU", LFU},
+	{"ARC", ARC},
+	{"TinyLFU", TinyLFU},
+}
+
+// 测试默认的 LRU 直接以 ByteView 为值类型，FIFO 经适配后仍然支持 Range
+func TestPolicyByteView(t *testing.T) {
+	if _, ok := LRU(0, nil).(*lru.Cache[string, ByteView]); !ok {
+		t.Fatal("LRU should be an lru.Cache[string, ByteView]")
+	}
+	var evicted []string
+	p := FIFO(10, func(key string, value ByteView, reason lru.EvictReason) {
+		evicted = append(evicted, key+"="+value.String())
+	})
+	p.Add("k1", ByteView{b: []byte("1234")})
+	p.Add("k2", ByteView{b: []byte("5678")})
+	if v, ok := p.Get("k2"); !ok || v.String() != "5678" {
+		t.Fatalf("Get(k2) = %q, %v", v.String(), ok)
+	}
+	if len(evicted) != 1 || evicted[0] != "k1=1234" {
+		t.Fatalf("evicted = %v, want [k1=1234]", evicted)
+	}
+	var keys []string
+	p.(RangePolicy).Range(func(key string, value ByteView) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if len(keys) != 1 || keys[0] != "k2" {
+		t.Fatalf("Range keys = %v, want [k2]", keys)
+	}
+}
+
+// 测试每种淘汰策略都能通过 WithPolicy 用在 Group 中，并且遵守内存上限
+func TestPolicies(t *testing.T) {
+	for _, p := range policies {
+		loads := 0
+		gee := NewGroup("policy-"+p.name, 64, GetterFunc(
+			func(key string) ([]byte, error) {
+				loads++
+				return []byte("value"), nil
+			}), WithPolicy(p.newPolicy))
+
+		for i := 0; i < 2; i++ {
+			if view, err := gee.Get("key"); err != nil || view.String() != "value" || loads != 1 {
+				t.Fatalf("%s: failed to get key from cache, loads %d", p.name, loads)
+			}
+		}
+		for i := 0; i < 100; i++ {
+			gee.Get(fmt.Sprintf("key%d", i))
+		}
+		if stats := gee.CacheStats(MainCache); stats.Bytes > 64 || stats.Evictions == 0 {
+			t.Fatalf("%s: expected evictions within 64 bytes, got %+v", p.name, stats)
+		}
+	}
+}
+
+// loadTrace 读取访问序列。设置环境变量 GEECACHE_TRACE 为一个每行一个 key 的文件时，
+// 回放该文件；否则生成一个服从 Zipf 分布的序列。
+func loadTrace(b *testing.B) []string {
+	if path := os.Getenv("GEECACHE_TRACE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer f.Close()
+		var trace []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			trace = append(trace, scanner.Text())
+		}
+		if err = scanner.Err(); err != nil {
+			b.Fatal(err)
+		}
+		return trace
+	}
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.1, 1, 100000)
+	trace := make([]string, 1000000)
+	for i := range trace {
+		trace[i] = fmt.Sprintf("key%d", zipf.Uint64())
+	}
+	return trace
+}
+
+// BenchmarkHitRatio 用同一个访问序列比较各淘汰策略的命中率，结果以 hit% 报告：
+//
+//	GEECACHE_TRACE=trace.txt go test -run=^$ -bench=HitRatio -benchtime=1x
+func BenchmarkHitRatio(b *testing.B) {
+	trace := loadTrace(b)
+	for _, cacheBytes := range []int64{1 << 14, 1 << 17} {
+		for _, p := range policies {
+			b.Run(fmt.Sprintf("%s/%dKB", p.name, cacheBytes>>10), func(b *testing.B) {
+				var hits, gets int
+				for n := 0; n < b.N; n++ {
+					c := &cache{cacheBytes: cacheBytes, newPolicy: p.newPolicy}
+					for _, key := range trace {
+						gets++
+						if _, ok := c.get(key); ok {
+							hits++
+							continue
+						}
+						c.add(key, ByteView{b: []byte("value")})
+					}
+				}
+				b.ReportMetric(100*float64(hits)/float64(gets), "hit%")
+			})
+		}
+	}
+}
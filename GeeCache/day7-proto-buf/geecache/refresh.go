@@ -0,0 +1,85 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// refreshAhead 是提前刷新模式的配置
+type refreshAhead struct {
+	ttl   time.Duration // 软过期时间，Getter 返回了有效期时以 Getter 的为准
+	grace time.Duration // 软过期之后还能返回旧值的时间，之后硬过期
+	beta  float64       // XFetch 的参数，越大越倾向于提前刷新，0 表示只在软过期后刷新
+}
+
+// WithRefreshAhead 开启提前刷新（stale-while-revalidate）模式。
+// 本地加载的值在 ttl 后软过期（Getter 返回了有效期时以它为准），再过 grace 后硬过期。
+// 软过期到硬过期之间 Get 立即返回旧值，同时在后台通过 loader 重新加载一次；硬过期后按未命中处理。
+// beta > 0 时使用 XFetch 概率性提前刷新：加载越慢、越接近软过期，越可能提前刷新，
+// 使各个节点的刷新时间错开，beta 为 1 是常用的取值。
+func WithRefreshAhead(ttl, grace time.Duration, beta float64) GroupOption {
+	return func(g *Group) {
+		g.refresh = &refreshAhead{ttl: ttl, grace: grace, beta: beta}
+	}
+}
+
+// setDeadlines 根据加载结果设置软、硬过期时间，ttl 是 Getter 返回的有效期，cost 是加载耗时
+func (r *refreshAhead) setDeadlines(value *ByteView, ttl, cost time.Duration) {
+	if ttl <= 0 {
+		ttl = r.ttl
+	}
+	if ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	value.s = now.Add(ttl)
+	value.e = value.s.Add(r.grace)
+	value.d = cost
+}
+
+// due 判断是否应该刷新：已经软过期，或者 XFetch 决定提前刷新。
+// XFetch: now - d * beta * ln(rand) >= 软过期时间
+func (r *refreshAhead) due(value ByteView, now time.Time) bool {
+	if value.s.IsZero() {
+		return false
+	}
+	if !now.Before(value.s) {
+		return true
+	}
+	if r.beta <= 0 || value.d <= 0 {
+		return false
+	}
+	early := float64(value.d) * r.beta * -math.Log(1-rand.Float64()) // 1-rand 在 (0, 1] 之间
+	return !now.Add(time.Duration(early)).Before(value.s)
+}
+
+// maybeRefresh 在命中的值需要刷新时，启动一个后台加载。同一个 key 同时只有一个后台加载。
+func (g *Group) maybeRefresh(key string, value ByteView) {
+	if g.refresh == nil {
+		return
+	}
+	now := time.Now()
+	if !g.refresh.due(value, now) {
+		return
+	}
+	if !now.Before(value.s) {
+		g.stats.staleHits.Add(1)
+	}
+	if _, running := g.refreshing.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+	g.stats.refreshes.Add(1)
+	go func() {
+		defer g.refreshing.Delete(key)
+		if _, err := g.load(context.Background(), key); err != nil {
+			if errors.Is(err, ErrNotFound) { // 数据源中已经删除，不再返回旧值
+				g.mainCache.remove(key)
+			}
+			log.Printf("[GeeCache] refresh %s failed: %v", key, err)
+		}
+	}()
+}
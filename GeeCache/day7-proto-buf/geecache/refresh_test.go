@@ -0,0 +1,84 @@
+package geecache
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor 轮询 cond，直到它返回 true 或超时
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var loads int32
+	gee := NewGroup("refresh-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			n := atomic.AddInt32(&loads, 1)
+			return []byte(strconv.Itoa(int(n))), nil
+		}), WithRefreshAhead(20*time.Millisecond, 50*time.Millisecond, 0))
+
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "1" {
+		t.Fatalf("failed to load Tom, got %s", view)
+	}
+	if view, _ := gee.Get("Tom"); view.String() != "1" || atomic.LoadInt32(&loads) != 1 {
+		t.Fatal("fresh value should be served without reloading")
+	}
+
+	// 软过期之后立即返回旧值，并且只触发一次后台加载
+	time.Sleep(25 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if view, err := gee.Get("Tom"); err != nil || view.String() != "1" {
+			t.Fatalf("stale value should be served, got %s", view)
+		}
+	}
+	waitFor(t, func() bool {
+		view, _ := gee.mainCache.get("Tom")
+		return view.String() == "2"
+	})
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("expected exactly one background reload, loads %d", n)
+	}
+	if stats := gee.Stats(); stats.StaleHits == 0 || stats.Refreshes != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// 硬过期之后按未命中处理，同步加载
+	time.Sleep(80 * time.Millisecond)
+	if view, err := gee.Get("Tom"); err != nil || view.String() != "3" {
+		t.Fatalf("expected synchronous reload after hard deadline, got %s", view)
+	}
+}
+
+func TestXFetch(t *testing.T) {
+	var loads int32
+	gee := NewGroup("xfetch-scores", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			time.Sleep(time.Millisecond) // 加载耗时越长越容易提前刷新
+			return []byte(db[key]), nil
+		}), WithRefreshAhead(time.Hour, time.Hour, 1e9))
+
+	gee.Get("Tom")
+	// beta 极大，离软过期还有一个小时也会提前刷新
+	gee.Get("Tom")
+	waitFor(t, func() bool { return atomic.LoadInt32(&loads) == 2 })
+	if stats := gee.Stats(); stats.StaleHits != 0 || stats.Refreshes == 0 {
+		t.Fatalf("expected an early refresh without stale hits, got %+v", stats)
+	}
+
+	r := &refreshAhead{beta: 1}
+	value := ByteView{s: time.Now().Add(time.Hour), d: time.Millisecond}
+	if r.due(value, time.Now()) {
+		t.Fatal("refresh should not be due long before the soft deadline")
+	}
+}
@@ -1,45 +1,320 @@
 package singleflight
 
-import "sync"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ErrGoexit 表示 fn 调用了 runtime.Goexit（例如测试中的 t.FailNow），没有返回结果
+var ErrGoexit = errors.New("singleflight: fn called runtime.Goexit")
+
+// PanicError 是 fn panic 时其他等待者得到的错误，Value 是 panic 的值，Stack 是 panic 时的调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: fn panicked: %v\n\n%s", p.Value, p.Stack)
+}
+
+// Unwrap 在 panic 的值是 error 时返回它
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
+
+func newPanicError(v interface{}) *PanicError {
+	stack := debug.Stack()
+	// 去掉第一行 "goroutine N [running]:"，这个协程号对其他等待者没有意义
+	if line := bytes.IndexByte(stack, '\n'); line >= 0 {
+		stack = stack[line+1:]
+	}
+	return &PanicError{Value: v, Stack: stack}
+}
+
+// Result 是 DoChan 返回的结果，Shared 表示结果是否被多个调用者共享
+type Result struct {
+	Val    interface{}
+	Err    error
+	Shared bool
+}
 
 // call 代表正在进行中，或已经结束的请求
 type call struct {
-	wg sync.WaitGroup // 使用 sync.WaitGroup 锁避免重入
-	val interface{}
-	err error
+	done    chan struct{} // 请求结束时关闭，所有等待者都在它上面等待
+	val     interface{}
+	err     error
+	waiters int                // 正在等待结果的调用者数，由 Group.mu 保护
+	dups    int                // 加入等待的重复调用者数，用于计算 shared，由 Group.mu 保护
+	chans   []chan<- Result    // DoChan 的调用者，请求结束时把结果发给它们
+	cancel  context.CancelFunc // 取消 fn 的 context，只有 DoContext 发起的请求才有
 }
 
 // Group 是 singleflight 的主数据结构，管理不同 key 的请求(call)
 type Group struct {
 	mu sync.Mutex // 保护m
-	m map[string]*call
+	m  map[string]*call
 }
 
 // 实现 Do 方法
 // Do 方法，接收 2 个参数，第一个参数是 key，第二个参数是一个函数 fn。
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// shared 表示结果是否同时返回给了多个调用者。
+// fn panic 时，panic 在调用 fn 的协程中以 *PanicError 重新抛出，其他等待者得到 *PanicError 错误。
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
 	g.mu.Lock() // g.mu 是保护 Group 的成员变量 m 不被并发读写而加上的锁
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	if c, ok := g.m[key]; ok {
+		c.waiters++ // Do 的调用者不会中途放弃，请求不会因为其他调用者取消而被取消
+		c.dups++
 		g.mu.Unlock()
-		c.wg.Wait() // 如果请求正在进行中，则等待
-		return c.val, c.err // // 请求结束，返回结果
+		<-c.done                  // 如果请求正在进行中，则等待
+		return c.val, c.err, true // 请求结束，返回结果
 	}
-	c := new(call)
-	c.wg.Add(1) // 发起请求前加锁
+	c := &call{done: make(chan struct{}), waiters: 1}
 	g.m[key] = c // 添加到 g.m，表明 key 已经有对应的请求在处理
 	g.mu.Unlock()
 
 	// Do 的作用就是，针对相同的 key，无论 Do 被调用多少次，
 	// 函数 fn 都只会被调用一次，等待 fn 调用结束了，返回返回值或错误。
-	c.val, c.err = fn() // 调用 fn，发起请求
-	c.wg.Done() // 请求结束
+	g.doCall(c, key, fn) // 调用 fn，发起请求
+	if pe, ok := c.err.(*PanicError); ok {
+		panic(pe)
+	}
+	return c.val, c.err, g.shared(c) // 返回结果
+}
 
+// DoChan 与 Do 相同，但不阻塞，结果通过返回的 channel 送达。
+// fn 在新的协程中执行，panic 时所有调用者都得到 *PanicError 错误。
+func (g *Group) DoChan(key string, fn func() (interface{}, error)) <-chan Result {
+	ch := make(chan Result, 1)
 	g.mu.Lock()
-	delete(g.m, key) // // 更新 g.m
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.waiters++
+		c.dups++
+		c.chans = append(c.chans, ch)
+		g.mu.Unlock()
+		return ch
+	}
+	c := &call{done: make(chan struct{}), waiters: 1, chans: []chan<- Result{ch}}
+	g.m[key] = c
 	g.mu.Unlock()
 
-	return c.val, c.err // 返回结果
-}
\ No newline at end of file
+	go g.doCall(c, key, fn)
+	return ch
+}
+
+// DoContext 与 Do 相同，但 fn 在新的协程中执行，并接收一个独立的 context：
+// 它保留第一个调用者 ctx 中的值，但不继承任何调用者的取消信号和截止时间。
+// 调用者的 ctx 被取消或到期时，DoContext 立即返回 ctx.Err()，其余调用者继续等待结果；
+// 只有所有调用者都放弃了，fn 的 context 才被取消。
+// fn panic 时，发起请求的调用者（如果还在等待）重新抛出 *PanicError，其他等待者得到 *PanicError 错误。
+func (g *Group) DoContext(ctx context.Context, key string, fn func(context.Context) (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		c.waiters++
+		c.dups++
+		g.mu.Unlock()
+		v, err = g.wait(ctx, key, c)
+		return v, err, true
+	}
+	fnCtx, cancel := context.WithCancel(detach(ctx))
+	c := &call{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	g.m[key] = c
+	g.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		g.doCall(c, key, func() (interface{}, error) {
+			return fn(fnCtx)
+		})
+	}()
+	v, err = g.wait(ctx, key, c)
+	if pe, ok := err.(*PanicError); ok {
+		panic(pe)
+	}
+	return v, err, g.shared(c)
+}
+
+// DoMulti 对 keys 中的每个 key 与 DoContext 相同，但新发起的 key 只调用一次 fn 批量获取：
+// 已经在进行中的 key 等待原来的请求，其余 key 交给 fn，fn 返回的 vals 和 errs 中都没有的 key 得到 nil, nil。
+// 调用者的 ctx 被取消时，还没有结果的 key 得到 ctx.Err()；本次发起的 key 都没有人等待时，fn 的 context 被取消。
+// fn panic 时，它负责的 key 都得到 *PanicError 错误，不会重新抛出。
+func (g *Group) DoMulti(ctx context.Context, keys []string, fn func(ctx context.Context, keys []string) (vals map[string]interface{}, errs map[string]error)) map[string]Result {
+	fnCtx, cancel := context.WithCancel(detach(ctx))
+	waiting := 0 // 本次发起的 key 中仍然有人等待的个数，由 g.mu 保护
+	release := func() {
+		if waiting--; waiting == 0 {
+			cancel()
+		}
+	}
+
+	calls := make(map[string]*call, len(keys))
+	var owned []string
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	for _, key := range keys {
+		if _, ok := calls[key]; ok {
+			continue
+		}
+		if c, ok := g.m[key]; ok {
+			c.waiters++
+			c.dups++
+			calls[key] = c
+			continue
+		}
+		c := &call{done: make(chan struct{}), waiters: 1, cancel: release}
+		g.m[key] = c
+		calls[key] = c
+		owned = append(owned, key)
+	}
+	waiting = len(owned)
+	g.mu.Unlock()
+
+	if len(owned) == 0 {
+		cancel()
+	} else {
+		go func() {
+			defer cancel()
+			var vals map[string]interface{}
+			var errs map[string]error
+			returned := false
+			first := calls[owned[0]]
+			// 在 defer 中结束其余的 key，fn 调用 runtime.Goexit 时也能唤醒它们的等待者
+			defer func() {
+				for _, key := range owned[1:] {
+					c := calls[key]
+					if returned {
+						c.val, c.err = vals[key], errs[key]
+					} else {
+						c.err = first.err // fn panic 或调用了 runtime.Goexit
+					}
+					g.finish(key, c)
+				}
+			}()
+			g.doCall(first, owned[0], func() (interface{}, error) {
+				vals, errs = fn(fnCtx, owned)
+				returned = true
+				return vals[owned[0]], errs[owned[0]]
+			})
+		}()
+	}
+
+	results := make(map[string]Result, len(calls))
+	for key, c := range calls {
+		v, err := g.wait(ctx, key, c)
+		results[key] = Result{Val: v, Err: err, Shared: g.shared(c)}
+	}
+	return results
+}
+
+// Forget 让 key 之后的调用重新执行 fn，而不是等待正在进行中的请求。
+// 已经在等待的调用者仍然得到原来请求的结果。
+func (g *Group) Forget(key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+}
+
+func (g *Group) shared(c *call) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return c.dups > 0
+}
+
+// doCall 调用 fn 并处理 panic 和 runtime.Goexit，保证无论如何都会唤醒等待者
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	normalReturn := false
+	recovered := false
+
+	// 使用两层 defer 区分 panic 和 runtime.Goexit
+	defer func() {
+		if !normalReturn && !recovered { // 既没有正常返回，也没有 panic，说明调用了 runtime.Goexit
+			c.err = ErrGoexit
+		}
+		g.finish(key, c)
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				// recover() 只在 panic 时返回非 nil，runtime.Goexit 时返回 nil
+				if r := recover(); r != nil {
+					c.err = newPanicError(r)
+				}
+			}
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// wait 等待请求结束，或者调用者的 ctx 被取消
+func (g *Group) wait(ctx context.Context, key string, c *call) (interface{}, error) {
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 && c.cancel != nil { // 没有人再等待结果，取消请求
+			c.cancel()
+			if g.m[key] == c { // 之后的调用者重新发起请求，而不是拿到被取消的结果
+				delete(g.m, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// finish 标记请求结束，唤醒所有等待者
+func (g *Group) finish(key string, c *call) {
+	g.mu.Lock()
+	if g.m[key] == c {
+		delete(g.m, key) // 更新 g.m
+	}
+	res := Result{Val: c.val, Err: c.err, Shared: c.dups > 0}
+	chans := c.chans
+	g.mu.Unlock()
+	close(c.done) // 请求结束
+	for _, ch := range chans {
+		ch <- res // channel 有 1 个缓冲，不会阻塞
+	}
+}
+
+// detachedContext 保留 parent 中的值，但不继承它的取消信号和截止时间
+type detachedContext struct {
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -1,16 +1,409 @@
 package singleflight
 
 import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDo(t *testing.T) {
 	var g Group
-	v, err := g.Do("key", func() (interface{}, error) {
+	v, err, _ := g.Do("key", func() (interface{}, error) {
 		return "bar", nil
 	})
 
 	if v != "bar" || err != nil {
 		t.Errorf("Do v = %v, error = %v", v, err)
 	}
-}
\ No newline at end of file
+}
+
+// 一个调用者的 ctx 被取消，不影响其他调用者拿到结果
+func TestDoContextCancelOneWaiter(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	fnCtx := make(chan context.Context, 1)
+	fn := func(ctx context.Context) (interface{}, error) {
+		fnCtx <- ctx
+		<-release
+		return "bar", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		_, err, _ := g.DoContext(ctx, "key", fn)
+		errCh <- err
+	}()
+	ctx1 := <-fnCtx // 第一个调用者的请求已经开始
+	resCh := make(chan interface{})
+	go func() {
+		v, _, _ := g.DoContext(context.Background(), "key", fn)
+		resCh <- v
+	}()
+
+	waitWaiters(t, &g, "key", 2) // 确保第二个调用者加入同一个请求
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("expected canceled caller to return context.Canceled, got %v", err)
+	}
+	if ctx1.Err() != nil {
+		t.Fatalf("fn context should not be canceled while others wait")
+	}
+	close(release)
+	if v := <-resCh; v != "bar" {
+		t.Fatalf("expected other caller to get bar, got %v", v)
+	}
+}
+
+// waitWaiters 等待 key 的请求有 n 个调用者在等待
+func waitWaiters(t *testing.T, g *Group, key string, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		g.mu.Lock()
+		c, ok := g.m[key]
+		joined := ok && c.waiters >= n
+		g.mu.Unlock()
+		if joined {
+			return
+		}
+	}
+	t.Fatalf("%d callers never joined %s", n, key)
+}
+
+// 所有调用者都放弃时，fn 的 context 被取消
+func TestDoContextCancelAllWaiters(t *testing.T) {
+	var g Group
+	canceled := make(chan struct{})
+	type ctxKey struct{}
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), ctxKey{}, "v"), 10*time.Millisecond)
+	defer cancel()
+	_, err, _ := g.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+		if ctx.Value(ctxKey{}) != "v" {
+			t.Errorf("fn context should keep values of the caller")
+		}
+		<-ctx.Done()
+		close(canceled)
+		return nil, ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("fn context should be canceled after all waiters left")
+	}
+}
+
+// deadlineContext 报告一个截止时间，但只在 cancel 时结束，测试不依赖计时
+type deadlineContext struct {
+	context.Context
+}
+
+func (deadlineContext) Deadline() (time.Time, bool) {
+	return time.Now().Add(time.Millisecond), true
+}
+
+// 第一个调用者到期不会取消其他调用者仍在等待的请求，fn 的 context 也不带截止时间
+func TestDoContextDeadline(t *testing.T) {
+	var g Group
+	parent, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ctx := deadlineContext{parent}
+	release := make(chan struct{})
+	fnCtx := make(chan context.Context, 1)
+	errCh := make(chan error)
+	go func() {
+		_, err, _ := g.DoContext(ctx, "key", func(ctx context.Context) (interface{}, error) {
+			fnCtx <- ctx
+			<-release
+			return "bar", nil
+		})
+		errCh <- err
+	}()
+	ctx1 := <-fnCtx
+	if _, ok := ctx1.Deadline(); ok {
+		t.Fatal("fn context should not inherit the caller's deadline")
+	}
+	resCh := make(chan interface{})
+	go func() {
+		v, _, _ := g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			t.Error("second caller should join the running call")
+			return nil, nil
+		})
+		resCh <- v
+	}()
+
+	waitWaiters(t, &g, "key", 2)
+	cancel() // 第一个调用者到期离开
+	if err := <-errCh; err != context.Canceled {
+		t.Fatalf("expected the first caller to leave, got %v", err)
+	}
+	if ctx1.Err() != nil {
+		t.Fatal("fn context should not be canceled while others wait")
+	}
+	close(release)
+	if v := <-resCh; v != "bar" {
+		t.Fatalf("expected the second caller to get bar, got %v", v)
+	}
+}
+
+// 并发调用同一个 key，fn 只执行一次，所有调用者都拿到 shared 的结果
+func TestDoDupSuppress(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := g.Do("key", fn)
+			if v != "bar" || err != nil || !shared {
+				t.Errorf("Do = %v, %v, shared %v", v, err, shared)
+			}
+		}()
+	}
+	waitWaiters(t, &g, "key", n) // 等所有调用者进入 Do
+	close(release)
+	wg.Wait()
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("fn called %d times, expected 1", got)
+	}
+
+	if _, _, shared := g.Do("key", func() (interface{}, error) { return "bar", nil }); shared {
+		t.Fatal("single caller should not report shared")
+	}
+}
+
+// fn panic 时，等待者得到 PanicError，调用 fn 的协程重新抛出 panic，之后的调用不受影响
+func TestDoPanic(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	waiterErr := make(chan error, 1)
+	go func() {
+		<-started
+		_, err, _ := g.Do("key", func() (interface{}, error) {
+			return nil, errors.New("should not be called")
+		})
+		waiterErr <- err
+	}()
+
+	func() {
+		defer func() {
+			r := recover()
+			if pe, ok := r.(*PanicError); !ok || pe.Value != "boom" {
+				t.Fatalf("expected *PanicError with boom, got %#v", r)
+			}
+		}()
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			waitWaiters(t, &g, "key", 2) // 等待者加入，Do 的 fn 在测试协程中执行
+			panic("boom")
+		})
+	}()
+
+	select {
+	case err := <-waiterErr:
+		var pe *PanicError
+		if !errors.As(err, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+			t.Fatalf("waiter expected PanicError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter deadlocked after panic")
+	}
+
+	v, err, _ := g.Do("key", func() (interface{}, error) { return "bar", nil })
+	if v != "bar" || err != nil {
+		t.Fatalf("Do after panic = %v, %v", v, err)
+	}
+}
+
+// fn 调用 runtime.Goexit 时，等待者得到 ErrGoexit
+func TestDoGoexit(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	go func() {
+		g.Do("key", func() (interface{}, error) {
+			close(started)
+			<-proceed
+			runtime.Goexit()
+			return nil, nil
+		})
+	}()
+	<-started
+	type result struct {
+		err    error
+		shared bool
+	}
+	resCh := make(chan result, 1)
+	go func() {
+		_, err, shared := g.Do("key", nil)
+		resCh <- result{err, shared}
+	}()
+	waitWaiters(t, &g, "key", 2) // 加入之后才能让 fn 结束，否则它会自己调用 nil fn
+	close(proceed)
+	if res := <-resCh; res.err != ErrGoexit || !res.shared {
+		t.Fatalf("expected ErrGoexit, got %v", res.err)
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+	chs := []<-chan Result{g.DoChan("key", fn), g.DoChan("key", fn)}
+	close(release)
+	for _, ch := range chs {
+		select {
+		case res := <-ch:
+			if res.Val != "bar" || res.Err != nil || !res.Shared {
+				t.Fatalf("unexpected result %+v", res)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("DoChan result not delivered")
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, expected 1", calls)
+	}
+
+	// DoChan 的 fn 在新协程中执行，panic 只以错误的形式返回
+	res := <-g.DoChan("panic", func() (interface{}, error) { panic("boom") })
+	var pe *PanicError
+	if !errors.As(res.Err, &pe) {
+		t.Fatalf("expected PanicError, got %v", res.Err)
+	}
+}
+
+// Forget 之后的调用重新执行 fn，已经在等待的调用者不受影响
+func TestForget(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	first := g.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+	g.Forget("key")
+	second := g.DoChan("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	if res := <-second; res.Val != 2 || res.Shared {
+		t.Fatalf("expected a fresh call after Forget, got %+v", res)
+	}
+	close(release)
+	if res := <-first; res.Val != 1 {
+		t.Fatalf("forgotten call should still deliver its result, got %+v", res)
+	}
+	// 被 Forget 的请求结束时不能删除新请求
+	third := g.DoChan("key", func() (interface{}, error) { return 3, nil })
+	if res := <-third; res.Val != 3 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+// DoContext 的 fn panic 时，发起请求的调用者重新抛出，其他调用者得到 PanicError
+func TestDoContextPanic(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	recovered := make(chan interface{}, 1)
+	go func() {
+		defer func() { recovered <- recover() }()
+		g.DoContext(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+			close(started)
+			<-proceed
+			panic("boom")
+		})
+	}()
+	<-started
+	waiterErr := make(chan error, 1)
+	go func() {
+		_, err, _ := g.DoContext(context.Background(), "key", nil)
+		waiterErr <- err
+	}()
+	waitWaiters(t, &g, "key", 2)
+	close(proceed)
+
+	if _, ok := (<-recovered).(*PanicError); !ok {
+		t.Fatal("expected the initiating caller to re-panic")
+	}
+	var pe *PanicError
+	if err := <-waiterErr; !errors.As(err, &pe) {
+		t.Fatalf("waiter expected PanicError, got %v", err)
+	}
+}
+
+// DoMulti 等待已经在进行中的 key，其余 key 只调用一次 fn
+func TestDoMulti(t *testing.T) {
+	var g Group
+	release := make(chan struct{})
+	running := g.DoChan("a", func() (interface{}, error) {
+		<-release
+		return "a1", nil
+	})
+	var fetched []string
+	done := make(chan map[string]Result)
+	go func() {
+		done <- g.DoMulti(context.Background(), []string{"a", "b", "c", "b"}, func(_ context.Context, keys []string) (map[string]interface{}, map[string]error) {
+			fetched = keys
+			return map[string]interface{}{"b": "b1"}, map[string]error{"c": errors.New("boom")}
+		})
+	}()
+	waitWaiters(t, &g, "a", 2)
+	close(release)
+	res := <-done
+	if len(fetched) != 2 || fetched[0] != "b" || fetched[1] != "c" {
+		t.Fatalf("fn should fetch only b and c, got %v", fetched)
+	}
+	if res["a"].Val != "a1" || !res["a"].Shared || res["b"].Val != "b1" || res["c"].Err == nil {
+		t.Fatalf("unexpected results %+v", res)
+	}
+	if r := <-running; r.Val != "a1" || !r.Shared {
+		t.Fatalf("unexpected result of the running call %+v", r)
+	}
+
+	// fn panic 时，它负责的 key 都得到 PanicError
+	res = g.DoMulti(context.Background(), []string{"x", "y"}, func(context.Context, []string) (map[string]interface{}, map[string]error) {
+		panic("boom")
+	})
+	var pe *PanicError
+	if !errors.As(res["x"].Err, &pe) || !errors.As(res["y"].Err, &pe) {
+		t.Fatalf("expected PanicError for every key, got %+v", res)
+	}
+
+	// 调用者放弃后，fn 的 context 被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	canceled := make(chan struct{})
+	res = g.DoMulti(ctx, []string{"slow"}, func(ctx context.Context, _ []string) (map[string]interface{}, map[string]error) {
+		<-ctx.Done()
+		close(canceled)
+		return nil, map[string]error{"slow": ctx.Err()}
+	})
+	if res["slow"].Err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", res["slow"].Err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("fn context should be canceled after the caller left")
+	}
+}
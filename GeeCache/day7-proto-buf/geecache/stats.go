@@ -0,0 +1,79 @@
+package geecache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt 是可以并发读写的 int64 计数器
+type AtomicInt int64
+
+// Add 原子地增加 n
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get 原子地读取当前值
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// groupStats 是 Group 内部的计数器
+type groupStats struct {
+	gets          AtomicInt // 所有 Get 请求
+	cacheHits     AtomicInt // mainCache 或 hotCache 命中
+	loads         AtomicInt // 缓存未命中，需要加载（包括被 singleflight 合并的）
+	loadsDeduped  AtomicInt // singleflight 合并之后真正执行的加载
+	peerLoads     AtomicInt // 从远程节点获取成功
+	peerErrors    AtomicInt // 从远程节点获取失败
+	localLoads    AtomicInt // 从本地数据源获取成功
+	localLoadErrs AtomicInt // 从本地数据源获取失败
+	negativeHits  AtomicInt // 负缓存命中，直接返回 ErrNotFound
+	bloomRejects  AtomicInt // 被布隆过滤器拦截，直接返回 ErrNotFound
+	staleHits     AtomicInt // 提前刷新模式下返回了软过期的旧值
+	refreshes     AtomicInt // 提前刷新模式下启动的后台加载
+}
+
+// Stats 是 Group 统计信息的快照
+type Stats struct {
+	Gets          int64      `json:"gets"`
+	CacheHits     int64      `json:"cache_hits"`
+	Loads         int64      `json:"loads"`
+	LoadsDeduped  int64      `json:"loads_deduped"`
+	PeerLoads     int64      `json:"peer_loads"`
+	PeerErrors    int64      `json:"peer_errors"`
+	LocalLoads    int64      `json:"local_loads"`
+	LocalLoadErrs int64      `json:"local_load_errs"`
+	NegativeHits  int64      `json:"negative_hits"`
+	BloomRejects  int64      `json:"bloom_rejects"`
+	StaleHits     int64      `json:"stale_hits"`
+	Refreshes     int64      `json:"refreshes"`
+	MainCache     CacheStats `json:"main_cache"`
+	HotCache      CacheStats `json:"hot_cache"`
+	NegativeCache CacheStats `json:"negative_cache"`
+}
+
+// Stats 返回 Group 当前的统计信息
+func (g *Group) Stats() Stats {
+	return Stats{
+		Gets:          g.stats.gets.Get(),
+		CacheHits:     g.stats.cacheHits.Get(),
+		Loads:         g.stats.loads.Get(),
+		LoadsDeduped:  g.stats.loadsDeduped.Get(),
+		PeerLoads:     g.stats.peerLoads.Get(),
+		PeerErrors:    g.stats.peerErrors.Get(),
+		LocalLoads:    g.stats.localLoads.Get(),
+		LocalLoadErrs: g.stats.localLoadErrs.Get(),
+		NegativeHits:  g.stats.negativeHits.Get(),
+		BloomRejects:  g.stats.bloomRejects.Get(),
+		StaleHits:     g.stats.staleHits.Get(),
+		Refreshes:     g.stats.refreshes.Get(),
+		MainCache:     g.mainCache.stats(),
+		HotCache:      g.hotCache.stats(),
+		NegativeCache: g.negCache.stats(),
+	}
+}
@@ -0,0 +1,78 @@
+package tinylfu
+
+import "hash/fnv"
+
+const (
+	sketchDepth   = 4  // count-min sketch 的行数
+	sketchMaxFreq = 15 // 计数器上限，和 4 bit 计数器一样
+)
+
+// cmSketch 是 count-min sketch，用很少的内存估计每个 key 的访问频率。
+// 每个 key 在每一行对应一个计数器，估计值取各行计数器的最小值。
+// 累计增加 sampleSize 次后所有计数器减半，让旧的访问记录逐渐失效（老化）。
+type cmSketch struct {
+	rows       [sketchDepth][]uint8
+	mask       uint64
+	additions  int
+	sampleSize int
+}
+
+func newCMSketch(width int) *cmSketch {
+	w := 1
+	for w < width { // 宽度取 2 的幂，方便用位运算取模
+		w <<= 1
+	}
+	s := &cmSketch{mask: uint64(w - 1), sampleSize: 10 * w}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+// index 用双重哈希为第 i 行计算下标
+func (s *cmSketch) index(h uint64, i int) uint64 {
+	h1, h2 := h, (h>>32)|1
+	return (h1 + uint64(i)*h2) & s.mask
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// increment 记录 key 的一次访问
+func (s *cmSketch) increment(key string) {
+	h := hash(key)
+	for i := range s.rows {
+		if idx := s.index(h, i); s.rows[i][idx] < sketchMaxFreq {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate 返回 key 的访问频率估计值
+func (s *cmSketch) estimate(key string) uint8 {
+	h := hash(key)
+	min := uint8(sketchMaxFreq)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// reset 所有计数器减半
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,248 @@
+package tinylfu
+
+import (
+	"container/list"
+	"geecache/lru"
+	"time"
+)
+
+// Value 与 lru.Value 相同，使用 Len 来计算它需要多少字节
+type Value = lru.Value
+
+// EvictReason 与 lru.EvictReason 相同，表示记录被移除的原因
+type EvictReason = lru.EvictReason
+
+const (
+	windowPercent    = 1  // 窗口区占总内存的百分比
+	protectedPercent = 80 // 保护区占主区的百分比
+)
+
+// Cache is a W-TinyLFU cache. It is not safe for concurrent access.
+//
+// 新记录先进入一个很小的窗口区 LRU（window），从窗口区淘汰的记录作为候选者，
+// 与主区的淘汰者比较 count-min sketch 估计的访问频率，频率更高的一方留在主区。
+// 主区是分段 LRU：新进入主区的记录在试用区（probation），再次命中后升级到保护区（protected）。
+// 窗口区让突发的新 key 有机会积累频率，sketch 让主区只接纳比淘汰者更热的 key。
+type Cache struct {
+	maxBytes     int64 // 允许使用的最大内存，0 表示不限制
+	windowMax    int64 // 窗口区的内存上限
+	protectedMax int64 // 保护区的内存上限
+
+	window, probation, protected                *list.List // front 为最近访问
+	windowBytes, probationBytes, protectedBytes int64
+
+	cache  map[string]*list.Element
+	sketch *cmSketch
+	// 可选并在清除entry时执行。
+	OnEvicted func(key string, value Value)
+	// 可选，与 OnEvicted 相同，但额外带上移除原因
+	OnEvictedWithReason func(key string, value Value, reason EvictReason)
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time  // 过期时间，零值表示永不过期
+	ll     *list.List // 所在的区
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key)) + int64(e.value.Len())
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// New 是 Cache 的构造函数
+func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+	mainMax := maxBytes - maxBytes*windowPercent/100
+	// 按平均每条记录 64 字节估计 sketch 的宽度
+	width := 1 << 16
+	if maxBytes > 0 {
+		width = int(maxBytes / 64)
+		if width < 1024 {
+			width = 1024
+		} else if width > 1<<22 {
+			width = 1 << 22
+		}
+	}
+	return &Cache{
+		maxBytes:     maxBytes,
+		windowMax:    maxBytes * windowPercent / 100,
+		protectedMax: mainMax * protectedPercent / 100,
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+		cache:        make(map[string]*list.Element),
+		sketch:       newCMSketch(width),
+		OnEvicted:    onEvicted,
+	}
+}
+
+// Get 查找键的值，无论是否命中都会记录一次访问
+func (c *Cache) Get(key string) (value Value, ok bool) {
+	c.sketch.increment(key)
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(ele, lru.EvictExpired)
+			return nil, false
+		}
+		c.onHit(ele)
+		return kv.value, true
+	}
+	return
+}
+
+// onHit 命中后调整记录的位置：试用区的记录升级到保护区，
+// 保护区超出上限时把最久没有访问的记录降级回试用区
+func (c *Cache) onHit(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	switch kv.ll {
+	case c.window, c.protected:
+		kv.ll.MoveToFront(ele)
+	case c.probation:
+		c.moveTo(ele, c.protected)
+		for c.protectedBytes > c.protectedMax && c.protected.Len() > 1 {
+			c.moveTo(c.protected.Back(), c.probation)
+		}
+	}
+}
+
+// moveTo 把记录移到 ll 的 front，返回新的节点
+func (c *Cache) moveTo(ele *list.Element, ll *list.List) *list.Element {
+	kv := ele.Value.(*entry)
+	c.detach(ele)
+	kv.ll = ll
+	ele = ll.PushFront(kv)
+	c.cache[kv.key] = ele
+	c.addBytes(ll, kv.size())
+	return ele
+}
+
+// detach 从所在的区中删除记录，但不触发回调
+func (c *Cache) detach(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	kv.ll.Remove(ele)
+	delete(c.cache, kv.key)
+	c.addBytes(kv.ll, -kv.size())
+}
+
+func (c *Cache) addBytes(ll *list.List, n int64) {
+	switch ll {
+	case c.window:
+		c.windowBytes += n
+	case c.probation:
+		c.probationBytes += n
+	case c.protected:
+		c.protectedBytes += n
+	}
+}
+
+// Remove 从缓存中删除 key 对应的记录
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, lru.EvictRemoved)
+	}
+}
+
+// RemoveExpired 移除所有已过期的记录，返回移除的条数
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, ll := range []*list.List{c.window, c.probation, c.protected} {
+		for ele := ll.Back(); ele != nil; {
+			prev := ele.Prev()
+			if ele.Value.(*entry).expired(now) {
+				c.removeElement(ele, lru.EvictExpired)
+				n++
+			}
+			ele = prev
+		}
+	}
+	return n
+}
+
+func (c *Cache) removeElement(ele *list.Element, reason EvictReason) {
+	kv := ele.Value.(*entry)
+	c.detach(ele)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+	if c.OnEvictedWithReason != nil {
+		c.OnEvictedWithReason(kv.key, kv.value, reason)
+	}
+}
+
+// Add 向缓存中添加一个值，该值永不过期
+func (c *Cache) Add(key string, value Value) {
+	c.AddWithTTL(key, value, 0)
+}
+
+// AddWithTTL 向缓存中添加一个值，ttl 之后该值过期；ttl <= 0 表示永不过期。
+// 新记录先进入窗口区，已存在的记录更新后视为一次命中。
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		c.addBytes(kv.ll, int64(value.Len())-int64(kv.value.Len()))
+		kv.value, kv.expire = value, expire
+		c.onHit(ele)
+	} else {
+		kv := &entry{key: key, value: value, expire: expire, ll: c.window}
+		c.cache[key] = c.window.PushFront(kv)
+		c.windowBytes += kv.size()
+	}
+	if c.maxBytes != 0 {
+		c.evict()
+	}
+}
+
+// evict 把窗口区溢出的记录交给主区做准入判断，直到总内存不超过 maxBytes
+func (c *Cache) evict() {
+	for c.windowBytes > c.windowMax && c.window.Len() > 0 {
+		candidate := c.moveTo(c.window.Back(), c.probation)
+		c.admit(candidate)
+	}
+	// 更新已有记录也可能让主区变大
+	for c.Bytes() > c.maxBytes {
+		ele := c.probation.Back()
+		if ele == nil {
+			ele = c.protected.Back()
+		}
+		if ele == nil {
+			ele = c.window.Back()
+		}
+		c.removeElement(ele, lru.EvictCapacity)
+	}
+}
+
+// admit 在主区内存不足时，比较候选者与淘汰者的访问频率，淘汰频率较低的一方
+func (c *Cache) admit(candidate *list.Element) {
+	key := candidate.Value.(*entry).key
+	for c.Bytes() > c.maxBytes {
+		victim := c.probation.Back()
+		if victim == candidate {
+			victim = c.protected.Back()
+		}
+		if victim == nil || c.sketch.estimate(key) <= c.sketch.estimate(victim.Value.(*entry).key) {
+			c.removeElement(candidate, lru.EvictCapacity)
+			return
+		}
+		c.removeElement(victim, lru.EvictCapacity)
+	}
+}
+
+// Len 用来获取添加了多少条数据
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+// Bytes 返回当前已使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.windowBytes + c.probationBytes + c.protectedBytes
+}
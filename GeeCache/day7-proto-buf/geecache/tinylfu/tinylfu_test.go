@@ -0,0 +1,71 @@
+package tinylfu
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (d String) Len() int {
+	return len(d)
+}
+
+// get 模拟 Group 的用法：先查询，未命中再添加
+func get(c *Cache, key string) {
+	if _, ok := c.Get(key); !ok {
+		c.Add(key, String("v"))
+	}
+}
+
+// 测试热点 key 不会被大量只访问一次的 key 冲掉
+func TestAdmission(t *testing.T) {
+	tinylfu := New(int64(100*len("k00v")), nil)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			get(tinylfu, fmt.Sprintf("k%02d", i))
+		}
+	}
+	for i := 0; i < 1000; i++ { // 一次性扫描
+		get(tinylfu, fmt.Sprintf("s%03d", i))
+	}
+	for i := 0; i < 50; i++ {
+		if _, ok := tinylfu.Get(fmt.Sprintf("k%02d", i)); !ok {
+			t.Fatalf("hot key k%02d should survive the scan", i)
+		}
+	}
+	if tinylfu.Bytes() > tinylfu.maxBytes {
+		t.Fatalf("cache uses %d bytes, exceeds %d", tinylfu.Bytes(), tinylfu.maxBytes)
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newCMSketch(64)
+	for i := 0; i < 5; i++ {
+		s.increment("hot")
+	}
+	s.increment("cold")
+	if s.estimate("hot") < 5 || s.estimate("cold") > s.estimate("hot") {
+		t.Fatalf("unexpected estimates hot=%d cold=%d", s.estimate("hot"), s.estimate("cold"))
+	}
+	s.reset()
+	if s.estimate("hot") > 3 {
+		t.Fatalf("reset should halve counters, got %d", s.estimate("hot"))
+	}
+}
+
+func TestAddWithTTL(t *testing.T) {
+	tinylfu := New(int64(0), nil)
+	tinylfu.AddWithTTL("k1", String("v1"), 10*time.Millisecond)
+	tinylfu.AddWithTTL("k2", String("v2"), 10*time.Millisecond)
+	tinylfu.Add("k3", String("v3"))
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := tinylfu.Get("k1"); ok {
+		t.Fatalf("k1 should be expired")
+	}
+	if n := tinylfu.RemoveExpired(); n != 1 || tinylfu.Len() != 1 || tinylfu.Bytes() != int64(len("k3v3")) {
+		t.Fatalf("expected only k3 left, removed %d", n)
+	}
+}
@@ -1,6 +1,6 @@
 module example
 
-go 1.17
+go 1.18
 
 require geecache v0.0.0
 
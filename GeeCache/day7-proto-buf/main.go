@@ -3,16 +3,29 @@ package main
 /*
 $ curl http://localhost:9999/_geecache/scores/Tom
 630
-$ curl http://localhost:9999/_geecache/scores/kkk
-kkk not exist
+$ curl -i http://localhost:9999/_geecache/scores/kkk
+HTTP/1.1 404 Not Found
+kkk not exist: geecache: key not found
+
+使用 -peer-admin 启动时，可以在运行中增删节点（需要对每个节点分别调用）:
+$ curl -X POST -d '{"peers":["http://localhost:8004"]}' http://localhost:8001/_geecache/_peers
+$ curl -X DELETE -d '{"peers":["http://localhost:8004"]}' http://localhost:8001/_geecache/_peers
+
+使用 -migrate-limit 启动时，节点变化后从原来的所有者迁移缓存记录，查看迁移进度:
+$ curl http://localhost:8004/_geecache/_migrations
 */
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"geecache"
+	"geecache/consistenthash"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // 使用 map 模拟了数据源 db
@@ -29,17 +42,37 @@ func createGroup() *geecache.Group {
 			if v, ok := db[key]; ok {
 				return []byte(v), nil
 			}
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s not exist: %w", key, geecache.ErrNotFound)
+		}),
+		geecache.WithNegativeCache(10*time.Second, 2<<8), // 不存在的 key 10 秒内不再查询 SlowDB
+		geecache.WithBloomFilter(1000, 0.01, func(ctx context.Context, add func(key string)) error {
+			for key := range db {
+				add(key)
+			}
+			return nil
+		}),
+		geecache.WithBloomRebuild(10*time.Minute))
 }
 
 // startCacheServer() 用来启动缓存服务器
-func startCacheServer(addr string, addrs []string, gee *geecache.Group) {
-	peers := geecache.NewHTTPPool(addr) // 创建 HTTPPool
-	peers.Set(addrs...)                 // 添加节点信息
-	gee.RegisterPeers(peers)            // 注册到 gee 中, 启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知
+func startCacheServer(addr string, addrs []string, gee *geecache.Group, opts ...geecache.HTTPPoolOption) {
+	peers := geecache.NewHTTPPool(addr, opts...) // 创建 HTTPPool
+	peers.AddPeers(addrs...)                     // 添加节点信息，开启 -peer-admin 时之后可以通过 /_geecache/_peers 增删
+	gee.RegisterPeers(peers)                     // 注册到 gee 中, 启动 HTTP 服务（共3个端口，8001/8002/8003），用户不感知
+	go func() {
+		// 优先从其他节点获取布隆过滤器，都还没有启动时再枚举 SlowDB
+		if err := gee.FetchBloomFilter(context.Background()); err != nil {
+			log.Println(err)
+			if err = gee.RebuildBloomFilter(context.Background()); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	mux := http.NewServeMux()
+	mux.Handle("/_geecache/", peers)
+	mux.HandleFunc("/metrics", peers.ServeMetrics) // Prometheus 指标
 	log.Println("geecache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], peers))
+	log.Fatal(http.ListenAndServe(addr[7:], mux))
 }
 
 // startAPIServer() 用来启动一个 API 服务（端口 9999），与用户进行交互，用户感知。
@@ -47,7 +80,11 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
-			view, err := gee.Get(key)
+			view, err := gee.GetContext(r.Context(), key)
+			if errors.Is(err, geecache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -59,29 +96,72 @@ func startAPIServer(apiAddr string, gee *geecache.Group) {
 	log.Fatal(http.ListenAndServe(apiAddr[7:], nil))
 }
 
+// splitPeers 按逗号拆分节点列表，"weight=N" 属于它前面的 "peer=URL"
+func splitPeers(s string) []string {
+	var peers []string
+	for _, field := range strings.Split(s, ",") {
+		if n := len(peers); n > 0 && strings.HasPrefix(field, "weight=") && strings.HasPrefix(peers[n-1], "peer=") {
+			peers[n-1] += "," + field
+			continue
+		}
+		peers = append(peers, field)
+	}
+	return peers
+}
+
 // main() 函数需要命令行传入 port 和 api 2 个参数，
 // 用来在指定端口启动 HTTP 服务。
 func main() {
 	var port int
 	var api bool
+	var peers string
+	var replication int
+	var boundedLoad float64
+	var placement string
+	var migrateLimit int
+	var peerAdmin bool
 	flag.IntVar(&port, "port", 8001, "Geecache server port")
 	flag.BoolVar(&api, "api", false, "Start a api server")
+	flag.StringVar(&peers, "peers", "", "Comma-separated initial peer addresses, defaults to localhost:8001-8003; peer=URL,weight=N sets a weight")
+	flag.IntVar(&replication, "replication", 2, "Number of replicas tried in order for each key")
+	flag.Float64Var(&boundedLoad, "bounded-load", 0, "Skip peers with more than (1+ε) times the average in-flight requests, 0 disables")
+	flag.StringVar(&placement, "placement", "ring", "Peer placement algorithm: ring, rendezvous, jump or maglev")
+	flag.IntVar(&migrateLimit, "migrate-limit", 0, "Pull up to N hot entries per group from previous owners when peers change, 0 disables")
+	flag.BoolVar(&peerAdmin, "peer-admin", false, "Allow adding and removing peers via POST and DELETE /_geecache/_peers; the endpoint is unauthenticated")
 	flag.Parse()
 
 	apiAddr := "http://localhost:9999"
-	addrMap := map[int]string{
-		8001: "http://localhost:8001",
-		8002: "http://localhost:8002",
-		8003: "http://localhost:8003",
+	// 默认节点列表使用固定的顺序，每个节点每次启动时加入节点的顺序都相同
+	addrs := []string{
+		"http://localhost:8001",
+		"http://localhost:8002",
+		"http://localhost:8003",
 	}
-
-	var addrs []string
-	for _, v := range addrMap {
-		addrs = append(addrs, v)
+	if peers != "" {
+		addrs = splitPeers(peers)
+	}
+	opts := []geecache.HTTPPoolOption{geecache.WithReplication(replication), geecache.WithBoundedLoad(boundedLoad)}
+	switch placement {
+	case "ring":
+	case "rendezvous":
+		opts = append(opts, geecache.WithPlacement(func() consistenthash.Placement { return consistenthash.NewRendezvous(nil) }))
+	case "jump":
+		opts = append(opts, geecache.WithPlacement(func() consistenthash.Placement { return consistenthash.NewJump(nil) }))
+	case "maglev":
+		opts = append(opts, geecache.WithPlacement(func() consistenthash.Placement { return consistenthash.NewMaglev(0, nil) }))
+	default:
+		log.Fatalf("unknown placement %q", placement)
 	}
+	if migrateLimit > 0 {
+		opts = append(opts, geecache.WithMigration(migrateLimit))
+	}
+	if peerAdmin {
+		opts = append(opts, geecache.WithPeerAdmin())
+	}
+
 	gee := createGroup()
 	if api {
 		go startAPIServer(apiAddr, gee)
 	}
-	startCacheServer(addrMap[port], addrs, gee)
+	startCacheServer(fmt.Sprintf("http://localhost:%d", port), addrs, gee, opts...)
 }